@@ -1,31 +1,218 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/aurora-is-near/tarserv/src/util"
 
 	"github.com/aurora-is-near/tarserv/src/tarindex"
 )
 
+// patternList is a repeatable flag.
+type patternList []string
+
+func (list *patternList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *patternList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+var (
+	workers      int
+	unsorted     bool
+	include      patternList
+	exclude      patternList
+	patternsFile string
+	ignoreFile   string
+	special      bool
+	xattrs       bool
+	sparse       bool
+	stat         bool
+	digest       bool
+	strict       bool
+	fromTar      string
+	roots        patternList
+)
+
+// skippedSuffix is appended to the name of the index file to name the report of skipped paths.
+const skippedSuffix = ".skipped"
+
+// skippedEntry is a line of the report of skipped paths.
+type skippedEntry struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+func init() {
+	flag.IntVar(&workers, "w", 0, "Number of directories to read concurrently.")
+	flag.BoolVar(&unsorted, "unsorted", false, "Keep directory entries in filesystem order instead of sorting them.")
+	flag.Var(&include, "include", "Only index files matching `pattern` (gitignore syntax). Can be repeated.")
+	flag.Var(&exclude, "exclude", "Do not index entries matching `pattern` (gitignore syntax). Can be repeated.")
+	flag.StringVar(&patternsFile, "patterns", "", "Read exclude patterns from `file` (gitignore syntax).")
+	flag.BoolVar(&special, "special", false, "Index FIFOs and device nodes.")
+	flag.BoolVar(&xattrs, "xattrs", false, "Capture extended attributes, ACLs and file capabilities.")
+	flag.BoolVar(&sparse, "sparse", false, "Detect holes in files and send them in the GNU sparse format.")
+	flag.BoolVar(&stat, "stat", false, "Record size and modification time of files for verification.")
+	flag.BoolVar(&digest, "digest", false, "Record size, modification time and SHA256 of files for verification.")
+	flag.BoolVar(&strict, "strict", false, "Fail on errors reading the source directory instead of skipping paths.")
+	flag.Var(&roots, "root", "Add the source directory `dir=prefix` under prefix in the tar. Can be repeated.")
+	flag.StringVar(&fromTar, "from-tar", "", "Index the existing `tarfile` instead of a source directory.")
+	flag.StringVar(&ignoreFile, "ignorefile", tarindex.DefaultIgnoreFile, "Name of per-directory ignore files. Empty to disable.")
+}
+
+// writeIndex writes the index of the source directories sources and the roots given with -root to f. A single
+// directory without prefix is the root of the tar, source directories are otherwise placed under their base name.
+func writeIndex(f *os.File, sources []string, options []tarindex.Option) error {
+	if len(sources) == 1 && len(roots) == 0 {
+		return tarindex.WriteIndex(sources[0], f, options...)
+	}
+	indexRoots := make([]tarindex.Root, 0, len(sources)+len(roots))
+	for _, source := range sources {
+		indexRoots = append(indexRoots, tarindex.DirRoot(source))
+	}
+	for _, value := range roots {
+		root, err := tarindex.ParseRoot(value)
+		if err != nil {
+			return err
+		}
+		indexRoots = append(indexRoots, root)
+	}
+	return tarindex.WriteRootsIndex(indexRoots, f, options...)
+}
+
+// indexTar writes the index of the tar file fromTar to indexFile.
+func indexTar(indexFile string) {
+	var options []tarindex.Option
+	if digest {
+		options = append(options, tarindex.OptDigest)
+	}
+	f, err := util.CreateFile(indexFile)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s: Error opening index file: %s\n", path.Base(os.Args[0]), err)
+		os.Exit(1)
+	}
+	defer func() { _ = f.Close() }()
+	if err := tarindex.WriteTarIndex(fromTar, f, options...); err != nil {
+		_ = f.Close()
+		_ = os.Remove(indexFile)
+		_, _ = fmt.Fprintf(os.Stderr, "%s: Error on tar file: %s\n", path.Base(os.Args[0]), err)
+		os.Exit(1)
+	}
+}
+
 func main() {
-	if len(os.Args) != 3 {
-		_, _ = fmt.Fprintf(os.Stderr, "%s <indexfile> <source directory>\n", path.Base(os.Args[0]))
+	flag.Parse()
+	args := flag.Args()
+	if fromTar != "" && len(args) == 1 {
+		indexTar(args[0])
+		os.Exit(0)
+	}
+	if len(args) < 1 || len(args) < 2 && len(roots) == 0 || fromTar != "" {
+		_, _ = fmt.Fprintf(os.Stderr, "%s [options] <indexfile> <source directory>\n", path.Base(os.Args[0]))
+		_, _ = fmt.Fprintf(os.Stderr, "%s [options] [-root <dir>=<prefix> ...] <indexfile> [<directory> ...]\n", path.Base(os.Args[0]))
+		_, _ = fmt.Fprintf(os.Stderr, "    Combines several source directories, each under its prefix in the tar (default ./<basename>).\n")
+		_, _ = fmt.Fprintf(os.Stderr, "%s [-digest] -from-tar <tarfile> <indexfile>\n", path.Base(os.Args[0]))
+		flag.PrintDefaults()
 		os.Exit(1)
 	}
-	f, err := util.CreateFile(os.Args[1])
+	reportName := args[0] + skippedSuffix
+	if _, err := os.Lstat(reportName); !os.IsNotExist(err) {
+		if err == nil {
+			err = os.ErrExist
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "%s: Error opening report file: %s\n", path.Base(os.Args[0]), err)
+		os.Exit(1)
+	}
+	var report *os.File // Created with the first skipped path.
+	defer func() {
+		if report != nil {
+			_ = report.Close()
+		}
+	}()
+	var skipped int
+	var reportErr error
+	var enc *json.Encoder
+	options := []tarindex.Option{
+		tarindex.OptWorkers(workers),
+		tarindex.OptReportSkipped(func(name string, err error) {
+			skipped++
+			if reportErr == nil && report == nil {
+				if report, reportErr = util.CreateFile(reportName); reportErr == nil {
+					enc = json.NewEncoder(report)
+				}
+			}
+			if reportErr == nil {
+				reportErr = enc.Encode(&skippedEntry{Path: name, Reason: err.Error()})
+			}
+		}),
+	}
+	if strict {
+		options = append(options, tarindex.OptStrict)
+	}
+	if special {
+		options = append(options, tarindex.OptSpecialFiles)
+	}
+	if xattrs {
+		options = append(options, tarindex.OptXattrs)
+	}
+	if sparse {
+		options = append(options, tarindex.OptSparse)
+	}
+	if digest {
+		options = append(options, tarindex.OptDigest)
+	} else if stat {
+		options = append(options, tarindex.OptStat)
+	}
+	if unsorted {
+		options = append(options, tarindex.OptUnsorted)
+	}
+	if patternsFile != "" {
+		patterns, err := tarindex.ReadPatternFile(patternsFile)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%s: Error reading patterns file: %s\n", path.Base(os.Args[0]), err)
+			os.Exit(1)
+		}
+		exclude = append(exclude, patterns...)
+	}
+	if len(include) > 0 {
+		options = append(options, tarindex.OptInclude(include...))
+	}
+	if len(exclude) > 0 {
+		options = append(options, tarindex.OptExclude(exclude...))
+	}
+	if ignoreFile != "" {
+		options = append(options, tarindex.OptIgnoreFile(ignoreFile))
+	}
+	f, err := util.CreateFile(args[0])
 	if err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "%s: Error opening index file: %s\n", path.Base(os.Args[0]), err)
 		os.Exit(1)
 	}
 	defer func() { _ = f.Close() }()
-	if err := tarindex.WriteIndex(os.Args[2], f); err != nil {
+	if err := writeIndex(f, args[1:], options); err != nil {
 		_ = f.Close()
-		_ = os.Remove(os.Args[1])
+		_ = os.Remove(args[0])
+		if report != nil {
+			_ = report.Close()
+			_ = os.Remove(reportName)
+		}
 		_, _ = fmt.Fprintf(os.Stderr, "%s: Error on source directory: %s\n", path.Base(os.Args[0]), err)
 		os.Exit(1)
 	}
+	if reportErr != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s: Error writing report file: %s\n", path.Base(os.Args[0]), reportErr)
+		os.Exit(1)
+	}
+	if skipped > 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "%s: Skipped %d paths, see %s\n", path.Base(os.Args[0]), skipped, reportName)
+	}
 	os.Exit(0)
 }
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/aurora-is-near/tarserv/src/tarindex"
+	"github.com/aurora-is-near/tarserv/src/util"
+)
+
+// errFound stops reading the index once the requested entry has been printed.
+var errFound = errors.New("found")
+
+func usage() {
+	name := path.Base(os.Args[0])
+	_, _ = fmt.Fprintf(os.Stderr, "%s ls <indexfile>: List entries with path, type, size, first and last byte in the tar.\n", name)
+	_, _ = fmt.Fprintf(os.Stderr, "%s stat <indexfile>: Show header, metadata, total size and entry count.\n", name)
+	_, _ = fmt.Fprintf(os.Stderr, "%s find <indexfile> <path>: Show the entry of path.\n", name)
+	_, _ = fmt.Fprintf(os.Stderr, "%s at <indexfile> <byte>: Show the entry that contains byte. Bytes after the last entry\n", name)
+	_, _ = fmt.Fprintf(os.Stderr, "    belong to the trailer of postfix files and the tar footer.\n")
+	_, _ = fmt.Fprintf(os.Stderr, "%s export [-format jsonl|csv|sql|sqlite] <indexfile> [<output>]: Export entries and\n", name)
+	_, _ = fmt.Fprintf(os.Stderr, "    metadata. Writes to stdout unless output is given. sqlite writes a SQLite database.\n")
+	os.Exit(1)
+}
+
+func fail(format string, args ...interface{}) {
+	_, _ = fmt.Fprintf(os.Stderr, "%s: %s\n", path.Base(os.Args[0]), fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// printEntry prints the path of e as contained in the tar stream, cleaned, its type, size in the tar stream, and first
+// and last byte (inclusive, like HTTP ranges).
+func printEntry(fixPath func(string) string, e *tarindex.ListEntry) {
+	_, _ = fmt.Fprintf(os.Stdout, "%s\t%s\t%d\t%d\t%d\n", path.Clean(fixPath(e.Name)), e.Type, e.Size, e.FirstByte, e.LastByte-1)
+}
+
+func list(f io.ReadSeeker, fixPath func(string) string) error {
+	return tarindex.ReadIndex(f, func(e *tarindex.ListEntry) error {
+		printEntry(fixPath, e)
+		return nil
+	})
+}
+
+func stat(f io.ReadSeeker, root string, size int64, headerErr error) error {
+	var entries int
+	var end int64
+	types := make(map[tarindex.EntryType]int)
+	if err := tarindex.ReadIndex(f, func(e *tarindex.ListEntry) error {
+		entries++
+		types[e.Type]++
+		end = e.LastByte
+		return nil
+	}); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "root\t%s\n", root)
+	if headerErr != nil {
+		_, _ = fmt.Fprintf(os.Stdout, "header\tmissing\n")
+	} else {
+		_, _ = fmt.Fprintf(os.Stdout, "size\t%d\n", size)
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "entries\t%d\n", entries)
+	_, _ = fmt.Fprintf(os.Stdout, "entrybytes\t%d\n", end)
+	for _, t := range []tarindex.EntryType{
+		tarindex.EntryTypeDirectory, tarindex.EntryTypeFile, tarindex.EntryTypeLink, tarindex.EntryTypeHardlink,
+		tarindex.EntryTypeFifo, tarindex.EntryTypeCharDevice, tarindex.EntryTypeBlockDevice,
+	} {
+		if types[t] > 0 {
+			_, _ = fmt.Fprintf(os.Stdout, "%s\t%d\n", t, types[t])
+		}
+	}
+	if headerErr != nil {
+		return nil
+	}
+	meta, err := tarindex.IndexMetadata(f)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		_, _ = fmt.Fprintf(os.Stdout, "meta.%s\t%s\n", key, meta[key])
+	}
+	return nil
+}
+
+func find(f io.ReadSeeker, fixPath func(string) string, name string) (bool, error) {
+	err := tarindex.ReadIndex(f, func(e *tarindex.ListEntry) error {
+		if path.Clean(fixPath(e.Name)) == path.Clean(name) {
+			printEntry(fixPath, e)
+			return errFound
+		}
+		return nil
+	})
+	if err == errFound {
+		return true, nil
+	}
+	return false, err
+}
+
+// at prints the entry that contains pos. Bytes after the last entry belong to postfix files and the tar footer.
+func at(f io.ReadSeeker, fixPath func(string) string, pos int64) (bool, error) {
+	var end int64
+	err := tarindex.ReadIndex(f, func(e *tarindex.ListEntry) error {
+		end = e.LastByte
+		if e.FirstByte <= pos && pos < e.LastByte {
+			printEntry(fixPath, e)
+			return errFound
+		}
+		return nil
+	})
+	if err == errFound {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if pos >= end {
+		_, _ = fmt.Fprintf(os.Stdout, "-\ttrailer\t-\t%d\t-\n", end)
+		return true, nil
+	}
+	return false, nil
+}
+
+func export(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.Usage = usage
+	format := flags.String("format", string(tarindex.ExportJSON), "Output format: jsonl, csv, sql or sqlite.")
+	_ = flags.Parse(args)
+	args = flags.Args()
+	if len(args) < 1 || len(args) > 2 {
+		usage()
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		fail("Error opening index file: %s", err)
+	}
+	defer func() { _ = f.Close() }()
+	out := os.Stdout
+	if len(args) == 2 && args[1] != "-" {
+		if out, err = util.CreateFile(args[1]); err != nil {
+			_ = f.Close()
+			fail("Error opening output file: %s", err)
+		}
+		defer func() { _ = out.Close() }()
+	}
+	if err := tarindex.ExportIndex(f, out, tarindex.ExportFormat(*format)); err != nil {
+		_ = f.Close()
+		if out != os.Stdout {
+			_ = out.Close()
+			_ = os.Remove(args[1])
+		}
+		fail("Error exporting index file: %s", err)
+	}
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+	}
+	command := args[0]
+	if command == "export" {
+		export(args[1:])
+		os.Exit(0)
+	}
+	switch {
+	case (command == "ls" || command == "stat") && len(args) == 2:
+	case (command == "find" || command == "at") && len(args) == 3:
+	default:
+		usage()
+	}
+	f, err := os.Open(args[1])
+	if err != nil {
+		fail("Error opening index file: %s", err)
+	}
+	defer func() { _ = f.Close() }()
+	size, root, headerErr := tarindex.IndexHeader(f)
+	if headerErr != nil && headerErr != tarindex.ErrMissingHeader {
+		fail("Error reading index file: %s", headerErr)
+	}
+	meta := make(tarindex.Metadata)
+	if headerErr == nil {
+		if meta, err = tarindex.IndexMetadata(f); err != nil {
+			fail("Error reading index file: %s", err)
+		}
+	}
+	fixPath := tarindex.IndexFixPath(root, meta)
+	found := true
+	switch command {
+	case "ls":
+		err = list(f, fixPath)
+	case "stat":
+		err = stat(f, root, size, headerErr)
+	case "find":
+		found, err = find(f, fixPath, args[2])
+	case "at":
+		pos, perr := strconv.ParseInt(args[2], 10, 64)
+		if perr != nil || pos < 0 {
+			fail("Invalid byte position: %s", args[2])
+		}
+		found, err = at(f, fixPath, pos)
+	}
+	if err != nil {
+		_ = f.Close()
+		fail("Error reading index file: %s", err)
+	}
+	if !found {
+		_ = f.Close()
+		fail("Not found: %s", args[2])
+	}
+	os.Exit(0)
+}
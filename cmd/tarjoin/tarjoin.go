@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/aurora-is-near/tarserv/src/splitting"
+	"github.com/aurora-is-near/tarserv/src/util"
+)
+
+func fail(err error) {
+	_, _ = fmt.Fprintf(os.Stderr, "%s ERROR: %s\n", path.Base(os.Args[0]), err)
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) != 3 {
+		_, _ = fmt.Fprintf(os.Stderr, "%s <input.tar.parts.json> <output.tar>\n", path.Base(os.Args[0]))
+		_, _ = fmt.Fprintf(os.Stderr, "    Joins the parts written by tarsplit. Use - to write to stdout.\n")
+		os.Exit(1)
+	}
+	out := os.Stdout
+	if os.Args[2] != "-" {
+		of, err := util.CreateFile(os.Args[2])
+		if err != nil {
+			fail(err)
+		}
+		defer func() { _ = of.Close() }()
+		out = of
+	}
+	w := bufio.NewWriterSize(out, 1<<20)
+	err := splitting.JoinTar(os.Args[1], w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil && out != os.Stdout {
+		err = out.Sync()
+	}
+	if err != nil {
+		if out != os.Stdout {
+			_ = out.Close()
+			_ = os.Remove(os.Args[2])
+		}
+		fail(err)
+	}
+	os.Exit(0)
+}
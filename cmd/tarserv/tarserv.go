@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/aurora-is-near/tarserv/src/deliver"
 )
@@ -16,18 +17,53 @@ var (
 	indexDir      string
 	listenAddress string
 	prefix        string
+	prefetch      int
+	readAdvice    bool
+	bufferSize    int
+	directIOSize  int64
+	cacheSize     int64
+	verify        time.Duration
+	verifyDigest  bool
+	keepSize      bool
+	serveIndex    bool
+	maxManifest   int64
 )
 
 func init() {
 	flag.StringVar(&indexDir, "i", "/var/snapshots/", "Directory containing index files produced by tarindex.")
 	flag.StringVar(&listenAddress, "l", "127.0.0.1:18123", "IP:Port to listen on.")
 	flag.StringVar(&prefix, "p", "/", "Request path.")
+	flag.IntVar(&prefetch, "r", 0, "Number of entries to open and read concurrently ahead of the tar stream.")
+	flag.BoolVar(&readAdvice, "fadvise", false, "Advise the kernel to drop served files from the page cache.")
+	flag.IntVar(&bufferSize, "b", 0, "Size of read buffer in bytes. Default 1MiB.")
+	flag.Int64Var(&directIOSize, "direct", 0, "Read files of at least this many bytes with O_DIRECT. 0 disables.")
+	flag.Int64Var(&cacheSize, "c", 0, "Bytes of memory used to cache index files. 0 disables.")
+	flag.DurationVar(&verify, "verify", 0, "Verify snapshots against their indexes at this interval. Broken snapshots are not served. 0 disables.")
+	flag.BoolVar(&verifyDigest, "verifydigest", false, "Compare file digests when verifying snapshots.")
+	flag.BoolVar(&keepSize, "keepsize", false, "Send files that changed size since indexing truncated or zero padded, instead of aborting.")
+	flag.Int64Var(&maxManifest, "maxmanifest", deliver.DefaultMaxManifestSize, "Maximum size of sync manifests in bytes.")
+	flag.BoolVar(&serveIndex, "serveindex", false, "Serve index files, which contain the paths of snapshots on this host.")
 }
 
 func main() {
 	flag.Parse()
 	h := &deliver.TarHandler{
-		IndexDirectory: indexDir,
+		IndexDirectory:  indexDir,
+		Prefetch:        prefetch,
+		ReadAdvice:      readAdvice,
+		BufferSize:      bufferSize,
+		DirectIOSize:    directIOSize,
+		KeepIndexedSize: keepSize,
+		ServeIndex:      serveIndex,
+		MaxManifestSize: maxManifest,
+	}
+	if cacheSize > 0 {
+		h.Cache = deliver.NewIndexCache(cacheSize)
+	}
+	if verify > 0 {
+		h.Verifier = deliver.NewIndexVerifier(indexDir, verify)
+		h.Verifier.Digest = verifyDigest
+		go h.Verifier.Run(nil)
 	}
 	mux := http.NewServeMux()
 	mux.Handle(prefix, http.StripPrefix(prefix, h))
@@ -39,7 +75,7 @@ func main() {
 		}
 	}()
 	log.Println("Running")
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGKILL, syscall.SIGTERM, syscall.SIGQUIT)
 	<-c
 	log.Println("Stop")
@@ -1,21 +1,61 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 
 	"github.com/aurora-is-near/tarserv/src/splitting"
+	"github.com/aurora-is-near/tarserv/src/util"
 )
 
+func usage() {
+	_, _ = fmt.Fprintf(os.Stderr, "%s [-n <parts>|-max-size <size>] [-out <dir>] [-remove] <input.tar>\n", path.Base(os.Args[0]))
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func fail(err error) {
+	_, _ = fmt.Fprintf(os.Stderr, "%s ERR: %s\n", path.Base(os.Args[0]), err)
+	os.Exit(1)
+}
+
 func main() {
-	if len(os.Args) != 2 {
-		_, _ = fmt.Fprintf(os.Stderr, "%s <input.tar>\n", path.Base(os.Args[0]))
-		os.Exit(1)
+	flag.Usage = usage
+	parts := flag.Int("n", 0, "Number of parts of about the same size. Default 2 unless -max-size is given.")
+	maxSizeArg := flag.String("max-size", "", "Maximum size of parts, for example 4GiB.")
+	outDir := flag.String("out", "", "Directory for parts and manifest. Default is the directory of the input.")
+	remove := flag.Bool("remove", false, "Remove the input after it was split successfully.")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+	}
+	input := flag.Arg(0)
+	var maxSize int64
+	if *maxSizeArg != "" {
+		var err error
+		if maxSize, err = util.ParseSize(*maxSizeArg); err != nil {
+			fail(err)
+		}
+	} else if *parts == 0 {
+		*parts = 2
+	}
+	if *outDir == "" {
+		*outDir = filepath.Dir(input)
+	}
+	manifest, err := splitting.SplitTar(input, *outDir, *parts, maxSize)
+	if err != nil {
+		fail(err)
+	}
+	for _, part := range manifest.Parts {
+		_, _ = fmt.Fprintf(os.Stdout, "%s\t%d\t%s\n", filepath.Join(*outDir, part.Name), part.Size, part.SHA256)
 	}
-	if err := splitting.SplitTarMiddle(os.Args[1]); err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "%s ERR: %s\n", path.Base(os.Args[0]), err)
-		os.Exit(1)
+	if *remove {
+		if err := os.Remove(input); err != nil {
+			fail(err)
+		}
 	}
 	os.Exit(0)
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/aurora-is-near/tarserv/src/tarindex"
+)
+
+var digest bool
+
+func init() {
+	flag.BoolVar(&digest, "digest", false, "Compare file digests, if recorded in the index (createindex -digest).")
+}
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) != 1 {
+		_, _ = fmt.Fprintf(os.Stderr, "%s [options] <indexfile>\n", path.Base(os.Args[0]))
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s: Error opening index file: %s\n", path.Base(os.Args[0]), err)
+		os.Exit(1)
+	}
+	defer func() { _ = f.Close() }()
+	report := func(e *tarindex.ListEntry, err error) {
+		_, _ = fmt.Fprintf(os.Stdout, "%s: %s\n", e.Name, err)
+	}
+	mismatches, err := tarindex.VerifyIndex(f, digest, report)
+	if err != nil {
+		_ = f.Close()
+		_, _ = fmt.Fprintf(os.Stderr, "%s: Error reading index file: %s\n", path.Base(os.Args[0]), err)
+		os.Exit(1)
+	}
+	if mismatches > 0 {
+		_ = f.Close()
+		_, _ = fmt.Fprintf(os.Stderr, "%s: %d entries do not match\n", path.Base(os.Args[0]), mismatches)
+		os.Exit(2)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,72 @@
+package deliver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/aurora-is-near/tarserv/src/tarindex"
+	"github.com/aurora-is-near/tarserv/src/util"
+)
+
+// defaultChunkSize is the chunk size if the request does not specify one.
+const defaultChunkSize = 1 << 30
+
+// chunkRange is a chunk together with the Range header that requests it.
+type chunkRange struct {
+	*tarindex.Chunk
+	Range string `json:"range"`
+}
+
+// chunkList is the response of ChunksHandler.
+type chunkList struct {
+	Size   int64         `json:"size"` // Size of the complete tar stream.
+	Chunks []*chunkRange `json:"chunks"`
+}
+
+// ChunksHandler responds with a JSON list of ranges of data.tar, of about the size given by the query parameter
+// "size" (for example "1GiB"), that start and end on entry boundaries. Hard links are in the chunk of their target.
+// Clients can download them in parallel with range requests and extract them independently or concatenate them.
+func (handler *TarHandler) ChunksHandler(w http.ResponseWriter, r *http.Request, idxName string) {
+	if !handler.servable(w, idxName) {
+		return
+	}
+	size := int64(defaultChunkSize)
+	if s := r.URL.Query().Get("size"); s != "" {
+		var err error
+		if size, err = util.ParseSize(s); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	f, err := handler.openIndex(idxName)
+	if err != nil {
+		log.Printf("ERROR: Index %s: %s", idxName, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	idxReader, err := tarindex.NewIndexReader(f, ioutil.Discard, versionFile(idxName))
+	if err != nil {
+		log.Printf("ERROR: Parse %s: %s", idxName, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	handler.configure(idxReader)
+	chunks, err := idxReader.Chunks(size)
+	if err != nil {
+		log.Printf("ERROR: Chunks %s: %s", idxName, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	list := &chunkList{Size: idxReader.Size(), Chunks: make([]*chunkRange, len(chunks))}
+	for i, chunk := range chunks {
+		list.Chunks[i] = &chunkRange{Chunk: chunk, Range: fmt.Sprintf("bytes=%d-%d", chunk.Start, chunk.End-1)}
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		log.Printf("ERROR: Chunks %s: %s", idxName, err)
+	}
+}
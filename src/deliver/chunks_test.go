@@ -0,0 +1,90 @@
+package deliver
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/aurora-is-near/tarserv/src/tarindex"
+)
+
+func TestChunksHandler(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := path.Join(dir, "snapshot")
+	if err := os.MkdirAll(snapshot, 0700); err != nil {
+		t.Fatalf("MkdirAll: %s", err)
+	}
+	for i := 0; i < 20; i++ {
+		content := bytes.Repeat([]byte{byte('a' + i)}, i*300)
+		if err := ioutil.WriteFile(path.Join(snapshot, fmt.Sprintf("file%02d", i)), content, 0600); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+	f, err := os.Create(indexFile(dir, "snap"))
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	if err := tarindex.WriteIndex(snapshot, f); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+	_ = f.Close()
+	handler := &TarHandler{IndexDirectory: dir}
+
+	w := httptest.NewRecorder()
+	handler.Handler(w, httptest.NewRequest(http.MethodGet, "/snap/data.tar", nil))
+	full := w.Body.Bytes()
+
+	w = httptest.NewRecorder()
+	handler.Handler(w, httptest.NewRequest(http.MethodGet, "/snap/chunks?size=4KiB", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Chunks: %d", w.Code)
+	}
+	list := new(chunkList)
+	if err := json.NewDecoder(w.Body).Decode(list); err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+	if list.Size != int64(len(full)) || len(list.Chunks) < 3 {
+		t.Fatalf("Wrong chunks: size %d of %d, %d chunks", list.Size, len(full), len(list.Chunks))
+	}
+	joined := new(bytes.Buffer)
+	for i, chunk := range list.Chunks {
+		if chunk.Start != int64(joined.Len()) || (i < len(list.Chunks)-1 && chunk.End-chunk.Start < 4096) {
+			t.Errorf("Wrong chunk %d: %d-%d", i, chunk.Start, chunk.End)
+		}
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/snap/data.tar", nil)
+		r.Header.Set("Range", chunk.Range)
+		handler.Handler(w, r)
+		if w.Code != http.StatusPartialContent || int64(w.Body.Len()) != chunk.End-chunk.Start {
+			t.Fatalf("Chunk %d: %d, %d bytes", i, w.Code, w.Body.Len())
+		}
+		tr := tar.NewReader(bytes.NewReader(w.Body.Bytes()))
+		hdr, err := tr.Next()
+		if err != nil || path.Clean(hdr.Name) != chunk.First {
+			t.Errorf("Chunk %d does not start with %s: %v", i, chunk.First, err)
+		}
+		for ; err == nil; _, err = tr.Next() {
+		}
+		if err != io.EOF {
+			t.Errorf("Chunk %d cannot be extracted: %s", i, err)
+		}
+		joined.Write(w.Body.Bytes())
+	}
+	if !bytes.Equal(joined.Bytes(), full) {
+		t.Errorf("Chunks differ from data.tar")
+	}
+
+	w = httptest.NewRecorder()
+	handler.Handler(w, httptest.NewRequest(http.MethodGet, "/snap/chunks?size=huge", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid size: %d", w.Code)
+	}
+}
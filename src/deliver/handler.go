@@ -5,6 +5,7 @@ package deliver
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -15,63 +16,191 @@ import (
 	"github.com/aurora-is-near/tarserv/src/tarindex"
 )
 
-const defaultFilename = "data.tar"
+const (
+	defaultFilename = "data.tar"
+	syncResource    = "sync"
+	indexResource   = "index" + indexSuffix
+	chunksResource  = "chunks"
+	partDirectory   = "part"
+)
 
 type TarHandler struct {
 	IndexDirectory string
+	Prefetch       int            // Number of entries to open and read concurrently ahead of the tar stream.
+	ReadAdvice     bool           // Keep served files out of the page cache, see tarindex.TarWriter.
+	BufferSize     int            // Read buffer size, see tarindex.TarWriter.
+	DirectIOSize   int64          // Minimum file size for O_DIRECT reads, see tarindex.TarWriter.
+	Cache          *IndexCache    // Optional cache of index files shared by all requests.
+	Verifier       *IndexVerifier // Optional background verification. Broken snapshots are not served.
+	// KeepIndexedSize sends files whose size changed since indexing with their indexed size, instead of aborting
+	// the download. See tarindex.IndexReader.KeepIndexedSize.
+	KeepIndexedSize bool
+	// ServeIndex serves the index files of snapshots, see IndexHandler. Index files contain the paths of snapshots and
+	// tar files on the server.
+	ServeIndex bool
+	// MaxManifestSize limits the size of manifests posted to SyncHandler, in bytes. Default DefaultMaxManifestSize.
+	MaxManifestSize int64
+}
+
+func (handler *TarHandler) configure(idxReader *tarindex.IndexReader) {
+	idxReader.Prefetch = handler.Prefetch
+	tw := idxReader.Writer()
+	tw.ReadAdvice = handler.ReadAdvice
+	tw.BufferSize = handler.BufferSize
+	tw.DirectIOSize = handler.DirectIOSize
+	if handler.KeepIndexedSize {
+		idxReader.KeepIndexedSize()
+	}
+}
+
+// logChanged logs files that were sent with their indexed size because their size changed.
+func logChanged(idxName string, idxReader *tarindex.IndexReader) {
+	if changed := idxReader.Writer().Changed(); len(changed) > 0 {
+		log.Printf("WARNING: %s: %d files changed size since indexing: %s", idxName, len(changed), strings.Join(changed, ", "))
+	}
 }
 
-func requestData(requestPath string) (index string) {
-	if p := strings.LastIndex(requestPath, defaultFilename); p > 0 {
-		requestPath = requestPath[0:p]
+// requestData returns the name of the index and the requested resource within it.
+func requestData(requestPath string) (index, resource string) {
+	dir, base := path.Split(path.Clean("/" + requestPath))
+	switch base {
+	case defaultFilename, syncResource, indexResource, chunksResource:
+		return path.Base(dir), base
+	}
+	if parent, partDir := path.Split(path.Clean(dir)); partDir == partDirectory && strings.HasSuffix(base, ".tar") {
+		return path.Base(parent), path.Join(partDirectory, base)
 	}
-	return path.Base(requestPath)
+	return path.Base(path.Join(dir, base)), ""
 }
 
 func (handler *TarHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	handler.Handler(w, r)
 }
 
-func parseRange(r string) (start, end int64) {
-	if pos := strings.Index(r, "="); pos < 0 {
-		return 0, 0
-	} else {
-		r = r[pos+1:]
-	}
-	if pos := strings.Index(r, "-"); pos < 0 {
-		return 0, 0
-	} else {
-		var start, stop int64
-		bs, es := r[:pos], r[pos+1:]
-		start, _ = strconv.ParseInt(bs, 10, 64)
-		stop, _ = strconv.ParseInt(es, 10, 64)
-		return start, stop
+// indexFile returns the path of the index file idxName in dir.
+func indexFile(dir, idxName string) string {
+	return path.Join(dir, idxName+indexSuffix)
+}
+
+func (handler *TarHandler) openIndex(idxName string) (io.ReadSeekCloser, error) {
+	idxFile := indexFile(handler.IndexDirectory, idxName)
+	if handler.Cache != nil {
+		return handler.Cache.Open(idxFile)
+	}
+	return os.Open(idxFile)
+}
+
+// servable returns false and responds with an error if the snapshot of idxName is known to be broken.
+func (handler *TarHandler) servable(w http.ResponseWriter, idxName string) bool {
+	if handler.Verifier == nil {
+		return true
+	}
+	if err := handler.Verifier.Broken(idxName); err != nil {
+		log.Printf("ERROR: Broken %s: %s", idxName, err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+func versionFile(idxName string) *tarindex.PostfixFile {
+	return &tarindex.PostfixFile{
+		Name:    ".version",
+		Content: []byte(idxName),
+	}
+}
+
+// parseRange returns the first byte and the byte after the last byte of a Range header for a tar stream of size bytes
+// (0 if unknown). The end is 0 if the range extends to the end, ends past the end of the stream are cut to its size.
+// A suffix range "-<n>" selects the last n bytes. Range headers that cannot be parsed are ignored. It returns false if
+// the range cannot be satisfied: its last byte is before its first byte, or it is a suffix range of a stream of
+// unknown size.
+func parseRange(r string, size int64) (start, end int64, ok bool) {
+	pos := strings.Index(r, "=")
+	if pos < 0 {
+		return 0, 0, true
+	}
+	r = r[pos+1:]
+	if pos = strings.Index(r, "-"); pos < 0 {
+		return 0, 0, true
 	}
+	bs, es := r[:pos], r[pos+1:]
+	if bs == "" {
+		n, err := strconv.ParseInt(es, 10, 64)
+		if err != nil {
+			return 0, 0, true
+		}
+		if n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, 0, true
+	}
+	start, err := strconv.ParseInt(bs, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, true
+	}
+	if es == "" {
+		return start, 0, true
+	}
+	stop, err := strconv.ParseInt(es, 10, 64)
+	if err != nil {
+		return 0, 0, true
+	}
+	if stop < start {
+		return 0, 0, false
+	}
+	end = stop + 1 // The last byte of a range is inclusive.
+	if size > 0 && end > size {
+		end = size
+	}
+	return start, end, true
 }
 
 func (handler *TarHandler) Handler(w http.ResponseWriter, r *http.Request) {
+	idxName, resource := requestData(r.URL.Path)
+	switch resource {
+	case syncResource:
+		handler.SyncHandler(w, r, idxName)
+		return
+	case indexResource:
+		handler.IndexHandler(w, r, idxName)
+		return
+	case chunksResource:
+		handler.ChunksHandler(w, r, idxName)
+		return
+	}
+	if dir, name := path.Split(resource); dir == partDirectory+"/" {
+		handler.PartHandler(w, r, idxName, name)
+		return
+	}
+	if !handler.servable(w, idxName) {
+		return
+	}
 	w.Header().Add("Accept-Ranges", "bytes")
 	filename := r.URL.Query().Get("lastfile")
-	startRange, endRange := parseRange(r.Header.Get("Range"))
-	idxName := requestData(r.URL.Path)
-	idxFile := path.Join(handler.IndexDirectory, fmt.Sprintf("%s.taridx", idxName))
-	f, err := os.Open(idxFile)
+	f, err := handler.openIndex(idxName)
 	if err != nil {
 		log.Printf("ERROR: Index %s: %s", idxName, err)
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 	defer func() { _ = f.Close() }()
-	postfixFile := &tarindex.PostfixFile{
-		Name:    ".version",
-		Content: []byte(idxName),
-	}
-	idxReader, err := tarindex.NewIndexReader(f, w, postfixFile)
+	idxReader, err := tarindex.NewIndexReader(f, w, versionFile(idxName))
 	if err != nil {
 		log.Printf("ERROR: Parse %s: %s", idxName, err)
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	handler.configure(idxReader)
+	startRange, endRange, ok := parseRange(r.Header.Get("Range"), idxReader.Size())
+	if !ok {
+		w.Header().Add("Content-Range", fmt.Sprintf("bytes */%d", idxReader.Size()))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
 	setFunc := func(length int64) {
 		w.Header().Add("Content-Type", "application/tar")
 		w.Header().Add("Content-Disposition", "attachment; filename=\"data.tar\"")
@@ -89,8 +218,12 @@ func (handler *TarHandler) Handler(w http.ResponseWriter, r *http.Request) {
 				w.Header().Add("Content-Range", rangeHeader)
 				w.WriteHeader(http.StatusPartialContent)
 			}
+		} else if length > 0 {
+			// A known length avoids chunked encoding, which allows net/http to send file content with sendfile.
+			w.Header().Add("Content-Length", strconv.FormatInt(length, 10))
 		}
 	}
+	defer logChanged(idxName, idxReader)
 	if _, err := idxReader.SeekAndWrite(filename, startRange, endRange, setFunc); err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		log.Printf("ERROR: Write %s (\"%s\", %d-%d): %s", idxName, filename, startRange, endRange, err)
@@ -1,9 +1,17 @@
 package deliver
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
 	"testing"
 	"time"
+
+	"github.com/aurora-is-near/tarserv/src/tarindex"
 )
 
 func TestHandler(t *testing.T) {
@@ -17,3 +25,83 @@ func TestHandler(t *testing.T) {
 	_ = http.ListenAndServe(address, mux)
 	time.Sleep(time.Hour)
 }
+
+func TestParseRange(t *testing.T) {
+	for _, test := range []struct {
+		header     string
+		size       int64
+		start, end int64
+		ok         bool
+	}{
+		{"", 1000, 0, 0, true},
+		{"bytes=0-0", 1000, 0, 1, true},
+		{"bytes=5-", 1000, 5, 0, true},
+		{"bytes=5-9", 1000, 5, 10, true},
+		{"bytes=-500", 1000, 500, 0, true},
+		{"bytes=-5000", 1000, 0, 0, true},
+		{"bytes=-500", 0, 0, 0, false},
+		{"bytes=-0", 1000, 0, 0, false},
+		{"bytes=900-5000", 1000, 900, 1000, true},
+		{"bytes=900-5000", 0, 900, 5001, true},
+		{"bytes=10-5", 1000, 0, 0, false},
+		{"bytes=a-5", 1000, 0, 0, true},
+		{"bytes=5", 1000, 0, 0, true},
+	} {
+		start, end, ok := parseRange(test.header, test.size)
+		if start != test.start || end != test.end || ok != test.ok {
+			t.Errorf("%q of %d: %d-%d %t", test.header, test.size, start, end, ok)
+		}
+	}
+}
+
+func TestRanges(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := path.Join(dir, "snapshot")
+	if err := os.MkdirAll(snapshot, 0700); err != nil {
+		t.Fatalf("MkdirAll: %s", err)
+	}
+	for i := 0; i < 5; i++ {
+		content := bytes.Repeat([]byte{byte('a' + i)}, i*300)
+		if err := ioutil.WriteFile(path.Join(snapshot, fmt.Sprintf("file%d", i)), content, 0600); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+	f, err := os.Create(indexFile(dir, "snap"))
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	if err := tarindex.WriteIndex(snapshot, f); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+	_ = f.Close()
+	handler := &TarHandler{IndexDirectory: dir}
+	w := httptest.NewRecorder()
+	handler.Handler(w, httptest.NewRequest(http.MethodGet, "/snap/data.tar", nil))
+	full := w.Body.Bytes()
+	size := len(full)
+
+	for _, test := range []struct {
+		header       string
+		code         int
+		start, end   int
+		contentRange string
+	}{
+		{"bytes=0-0", http.StatusPartialContent, 0, 1, fmt.Sprintf("bytes 0-0/%d", size)},
+		{"bytes=600-", http.StatusPartialContent, 600, size, fmt.Sprintf("bytes 600-%d/%d", size-1, size)},
+		{"bytes=-500", http.StatusPartialContent, size - 500, size, fmt.Sprintf("bytes %d-%d/%d", size-500, size-1, size)},
+		{fmt.Sprintf("bytes=600-%d", size+1000), http.StatusPartialContent, 600, size,
+			fmt.Sprintf("bytes 600-%d/%d", size-1, size)},
+		{"bytes=10-5", http.StatusRequestedRangeNotSatisfiable, 0, 0, fmt.Sprintf("bytes */%d", size)},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/snap/data.tar", nil)
+		r.Header.Set("Range", test.header)
+		handler.Handler(w, r)
+		if w.Code != test.code || w.Header().Get("Content-Range") != test.contentRange {
+			t.Errorf("%s: %d, %s", test.header, w.Code, w.Header().Get("Content-Range"))
+		}
+		if !bytes.Equal(w.Body.Bytes(), full[test.start:test.end]) {
+			t.Errorf("%s: wrong content, %d bytes", test.header, w.Body.Len())
+		}
+	}
+}
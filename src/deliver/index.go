@@ -0,0 +1,41 @@
+package deliver
+
+import (
+	"log"
+	"net/http"
+	"os"
+)
+
+// IndexHandler serves the index file of a snapshot, with support for range requests, so that clients can calculate
+// the offsets of entries in the tar stream themselves. Paths in the index are those on the server, including the
+// source directories and tar files in its header, see tarindex.IndexFixPath for their paths in the tar stream.
+// Index files are therefore only served if enabled with ServeIndex.
+func (handler *TarHandler) IndexHandler(w http.ResponseWriter, r *http.Request, idxName string) {
+	if !handler.ServeIndex {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Add("Allow", http.MethodGet+", "+http.MethodHead)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !handler.servable(w, idxName) {
+		return
+	}
+	fi, err := os.Stat(indexFile(handler.IndexDirectory, idxName))
+	if err != nil {
+		log.Printf("ERROR: Index %s: %s", idxName, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f, err := handler.openIndex(idxName)
+	if err != nil {
+		log.Printf("ERROR: Index %s: %s", idxName, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, indexResource, fi.ModTime(), f)
+}
@@ -0,0 +1,65 @@
+package deliver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/aurora-is-near/tarserv/src/tarindex"
+)
+
+func TestIndexHandler(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := path.Join(dir, "snapshot")
+	if err := os.MkdirAll(snapshot, 0700); err != nil {
+		t.Fatalf("MkdirAll: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(snapshot, "file"), []byte("content"), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	f, err := os.Create(indexFile(dir, "snap"))
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	if err := tarindex.WriteIndex(snapshot, f); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+	_ = f.Close()
+	index, err := ioutil.ReadFile(indexFile(dir, "snap"))
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+
+	for _, handler := range []*TarHandler{
+		{IndexDirectory: dir, ServeIndex: true},
+		{IndexDirectory: dir, ServeIndex: true, Cache: NewIndexCache(1 << 20)},
+	} {
+		w := httptest.NewRecorder()
+		handler.Handler(w, httptest.NewRequest(http.MethodGet, "/snap/index.taridx", nil))
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), index) {
+			t.Errorf("Wrong index: %d, %d bytes", w.Code, w.Body.Len())
+		}
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/snap/index.taridx", nil)
+		r.Header.Set("Range", "bytes=265-529")
+		handler.Handler(w, r)
+		if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), index[265:530]) {
+			t.Errorf("Wrong range: %d, %d bytes", w.Code, w.Body.Len())
+		}
+	}
+	w := httptest.NewRecorder()
+	handler := &TarHandler{IndexDirectory: dir, ServeIndex: true}
+	handler.Handler(w, httptest.NewRequest(http.MethodGet, "/missing/index.taridx", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Missing index: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	(&TarHandler{IndexDirectory: dir}).Handler(w, httptest.NewRequest(http.MethodGet, "/snap/index.taridx", nil))
+	if w.Code != http.StatusNotFound || w.Body.Len() != 0 {
+		t.Errorf("Index served without ServeIndex: %d", w.Code)
+	}
+}
@@ -0,0 +1,144 @@
+package deliver
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// IndexCache keeps recently used index files in memory, so that concurrent and repeated requests for the same
+// snapshot do not read the index from disk again. Cached indexes are invalidated when the file is replaced or
+// modified. Index files larger than MaxBytes are never cached.
+type IndexCache struct {
+	MaxBytes int64 // Memory budget for all cached indexes.
+
+	mutex   sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type cachedIndex struct {
+	name  string
+	fi    os.FileInfo
+	data  []byte
+	err   error
+	ready chan struct{} // Closed when loading has finished.
+}
+
+type bytesReadSeekCloser struct {
+	*bytes.Reader
+}
+
+func (r bytesReadSeekCloser) Close() error {
+	return nil
+}
+
+// NewIndexCache returns an IndexCache that uses up to maxBytes of memory.
+func NewIndexCache(maxBytes int64) *IndexCache {
+	return &IndexCache{
+		MaxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func sameIndex(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+// Open returns the content of the index file name.
+func (cache *IndexCache) Open(name string) (io.ReadSeekCloser, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > cache.MaxBytes {
+		return os.Open(name)
+	}
+	cache.mutex.Lock()
+	if e, ok := cache.entries[name]; ok {
+		entry := e.Value.(*cachedIndex)
+		select {
+		case <-entry.ready:
+			if entry.err == nil && !sameIndex(entry.fi, fi) {
+				cache.remove(e)
+				break
+			}
+			cache.lru.MoveToFront(e)
+			cache.mutex.Unlock()
+			return entry.reader()
+		default:
+			cache.lru.MoveToFront(e)
+			cache.mutex.Unlock()
+			<-entry.ready
+			return entry.reader()
+		}
+	}
+	entry := &cachedIndex{
+		name:  name,
+		ready: make(chan struct{}),
+	}
+	e := cache.lru.PushFront(entry)
+	cache.entries[name] = e
+	cache.mutex.Unlock()
+
+	entry.fi, entry.data, entry.err = readIndex(name)
+	cache.mutex.Lock()
+	close(entry.ready)
+	if entry.err != nil {
+		cache.remove(e)
+	} else {
+		cache.size += int64(len(entry.data))
+		cache.evict()
+	}
+	cache.mutex.Unlock()
+	return entry.reader()
+}
+
+func readIndex(name string) (os.FileInfo, []byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = f.Close() }()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	return fi, data, err
+}
+
+func (entry *cachedIndex) reader() (io.ReadSeekCloser, error) {
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	return bytesReadSeekCloser{bytes.NewReader(entry.data)}, nil
+}
+
+// remove drops e from the cache. Must be called with mutex held.
+func (cache *IndexCache) remove(e *list.Element) {
+	entry := e.Value.(*cachedIndex)
+	cache.lru.Remove(e)
+	if cache.entries[entry.name] == e {
+		delete(cache.entries, entry.name)
+	}
+	cache.size -= int64(len(entry.data))
+}
+
+// evict drops the least recently used indexes until the cache fits into MaxBytes. Must be called with mutex held.
+func (cache *IndexCache) evict() {
+	for e := cache.lru.Back(); e != nil && cache.size > cache.MaxBytes; {
+		prev := e.Prev()
+		select {
+		case <-e.Value.(*cachedIndex).ready:
+			cache.remove(e)
+		default:
+		}
+		e = prev
+	}
+}
@@ -0,0 +1,69 @@
+package deliver
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func readCached(t *testing.T, cache *IndexCache, name string) string {
+	r, err := cache.Open(name)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer func() { _ = r.Close() }()
+	d, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	return string(d)
+}
+
+func TestIndexCache(t *testing.T) {
+	tdirName, err := ioutil.TempDir(os.TempDir(), "indexcache.")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer func() { _ = os.RemoveAll(tdirName) }()
+	a, b, big := path.Join(tdirName, "a"), path.Join(tdirName, "b"), path.Join(tdirName, "big")
+	_ = ioutil.WriteFile(a, []byte("aaaa"), 0600)
+	_ = ioutil.WriteFile(b, []byte("bbbb"), 0600)
+	_ = ioutil.WriteFile(big, []byte("0123456789"), 0600)
+	cache := NewIndexCache(8)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := cache.Open(a)
+			if err != nil {
+				t.Errorf("Open: %s", err)
+				return
+			}
+			if d, _ := ioutil.ReadAll(r); string(d) != "aaaa" {
+				t.Errorf("Wrong content: %s", d)
+			}
+		}()
+	}
+	wg.Wait()
+	if cache.lru.Len() != 1 || cache.size != 4 {
+		t.Errorf("Not cached: %d entries, %d bytes", cache.lru.Len(), cache.size)
+	}
+	if d := readCached(t, cache, big); d != "0123456789" || cache.lru.Len() != 1 {
+		t.Errorf("Oversized index: %s, %d entries", d, cache.lru.Len())
+	}
+	_ = ioutil.WriteFile(a, []byte("AAAAA"), 0600)
+	_ = os.Chtimes(a, time.Now(), time.Now().Add(time.Hour))
+	if d := readCached(t, cache, a); d != "AAAAA" {
+		t.Errorf("Not invalidated: %s", d)
+	}
+	if d := readCached(t, cache, b); d != "bbbb" || cache.size != 4 || cache.lru.Len() != 1 {
+		t.Errorf("Not evicted: %d entries, %d bytes", cache.lru.Len(), cache.size)
+	}
+	if _, err := cache.Open(path.Join(tdirName, "missing")); err == nil {
+		t.Error("Missing index opened")
+	}
+}
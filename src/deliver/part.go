@@ -0,0 +1,77 @@
+package deliver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aurora-is-near/tarserv/src/tarindex"
+)
+
+// maxParts limits the number of parts a snapshot can be split into.
+const maxParts = 10000
+
+// parsePart returns i and n of a part name "<i>-of-<n>.tar", with 1 <= i <= n.
+func parsePart(name string) (i, n int, ok bool) {
+	if _, err := fmt.Sscanf(name, "%d-of-%d.tar", &i, &n); err != nil {
+		return 0, 0, false
+	}
+	if fmt.Sprintf("%d-of-%d.tar", i, n) != name || i < 1 || i > n || n > maxParts {
+		return 0, 0, false
+	}
+	return i, n, true
+}
+
+// PartHandler sends part i of n of data.tar, requested as part/<i>-of-<n>.tar. Every part is a complete tar of a
+// contiguous group of entries with its own .version file and end of the archive, parts are of about the same size.
+// Hard links are in the part of their target. Extracting all parts gives the content of data.tar.
+func (handler *TarHandler) PartHandler(w http.ResponseWriter, r *http.Request, idxName, name string) {
+	i, n, ok := parsePart(name)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !handler.servable(w, idxName) {
+		return
+	}
+	f, err := handler.openIndex(idxName)
+	if err != nil {
+		log.Printf("ERROR: Index %s: %s", idxName, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	idxReader, err := tarindex.NewIndexReader(f, ioutil.Discard, versionFile(idxName))
+	if err != nil {
+		log.Printf("ERROR: Parse %s: %s", idxName, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	handler.configure(idxReader)
+	parts, err := idxReader.Parts(n)
+	if err != nil {
+		log.Printf("ERROR: Parts %s: %s", idxName, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	// The parts are computed from a complete pass over the index, the part is written with a second reader.
+	if idxReader, err = tarindex.NewIndexReader(f, w, versionFile(idxName)); err != nil {
+		log.Printf("ERROR: Parse %s: %s", idxName, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	handler.configure(idxReader)
+	part := parts[i-1]
+	w.Header().Add("Content-Type", "application/tar")
+	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-%s\"", idxName, name))
+	w.Header().Add("Content-Length", strconv.FormatInt(idxReader.PartSize(part), 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	defer logChanged(idxName, idxReader)
+	if _, err := idxReader.WritePart(part); err != nil {
+		log.Printf("ERROR: Write %s (part %d of %d): %s", idxName, i, n, err)
+	}
+}
@@ -0,0 +1,89 @@
+package deliver
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/aurora-is-near/tarserv/src/tarindex"
+)
+
+func TestPartHandler(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := path.Join(dir, "snapshot")
+	if err := os.MkdirAll(snapshot, 0700); err != nil {
+		t.Fatalf("MkdirAll: %s", err)
+	}
+	files := make(map[string][]byte)
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("file%02d", i)
+		files[name] = bytes.Repeat([]byte{byte('a' + i)}, i*300)
+		if err := ioutil.WriteFile(path.Join(snapshot, name), files[name], 0600); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+	f, err := os.Create(indexFile(dir, "snap"))
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	if err := tarindex.WriteIndex(snapshot, f); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+	_ = f.Close()
+	handler := &TarHandler{IndexDirectory: dir}
+
+	for _, n := range []int{1, 3, 50} {
+		extracted := make(map[string][]byte)
+		for i := 1; i <= n; i++ {
+			w := httptest.NewRecorder()
+			handler.Handler(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/snap/part/%d-of-%d.tar", i, n), nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Part %d of %d: %d", i, n, w.Code)
+			}
+			if length := w.Header().Get("Content-Length"); length != strconv.Itoa(w.Body.Len()) {
+				t.Errorf("Part %d of %d: Content-Length %s, %d bytes", i, n, length, w.Body.Len())
+			}
+			var version bool
+			tr := tar.NewReader(w.Body)
+			hdr, err := tr.Next()
+			for ; err == nil; hdr, err = tr.Next() {
+				content, err := ioutil.ReadAll(tr)
+				if err != nil {
+					t.Fatalf("Part %d of %d: %s", i, n, err)
+				}
+				if hdr.Name == ".version" {
+					version = string(content) == "snap"
+				} else if hdr.Typeflag == tar.TypeReg {
+					extracted[path.Base(hdr.Name)] = content
+				}
+			}
+			if err != io.EOF || !version {
+				t.Errorf("Part %d of %d invalid: %v, version %t", i, n, err, version)
+			}
+		}
+		if len(extracted) != len(files) {
+			t.Errorf("%d parts: %d of %d files", n, len(extracted), len(files))
+		}
+		for name, content := range files {
+			if !bytes.Equal(extracted[name], content) {
+				t.Errorf("%d parts: wrong content of %s", n, name)
+			}
+		}
+	}
+
+	for _, name := range []string{"0-of-2.tar", "3-of-2.tar", "01-of-2.tar", "1-of-2"} {
+		w := httptest.NewRecorder()
+		handler.Handler(w, httptest.NewRequest(http.MethodGet, "/snap/part/"+name, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Invalid part %s: %d", name, w.Code)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package deliver
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/aurora-is-near/tarserv/src/tarindex"
+)
+
+// DefaultMaxManifestSize is the default of TarHandler.MaxManifestSize.
+const DefaultMaxManifestSize = 256 << 20
+
+// SyncHandler serves a tar stream that contains only the entries the receiver lacks. The receiver POSTs its
+// manifest as JSON lines (see tarindex.ManifestEntry). Paths to delete are listed in tarindex.DeleteFileName.
+// Manifests larger than MaxManifestSize and manifests with paths outside of the snapshot are rejected.
+func (handler *TarHandler) SyncHandler(w http.ResponseWriter, r *http.Request, idxName string) {
+	if r.Method != http.MethodPost {
+		w.Header().Add("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !handler.servable(w, idxName) {
+		return
+	}
+	maxSize := handler.MaxManifestSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxManifestSize
+	}
+	manifest, err := tarindex.ReadManifest(http.MaxBytesReader(w, r.Body, maxSize))
+	if err != nil {
+		log.Printf("ERROR: Manifest %s: %s", idxName, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f, err := handler.openIndex(idxName)
+	if err != nil {
+		log.Printf("ERROR: Index %s: %s", idxName, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	idxReader, err := tarindex.NewIndexReader(f, w, versionFile(idxName))
+	if err != nil {
+		log.Printf("ERROR: Parse %s: %s", idxName, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	handler.configure(idxReader)
+	w.Header().Add("Content-Type", "application/tar")
+	w.Header().Add("Content-Disposition", "attachment; filename=\"sync.tar\"")
+	defer logChanged(idxName, idxReader)
+	if _, err := idxReader.WriteSync(manifest); err != nil {
+		log.Printf("ERROR: Sync %s (%d entries): %s", idxName, len(manifest), err)
+		return
+	}
+}
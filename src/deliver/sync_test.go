@@ -0,0 +1,54 @@
+package deliver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/aurora-is-near/tarserv/src/tarindex"
+)
+
+func TestSyncHandlerManifest(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := path.Join(dir, "snapshot")
+	if err := os.MkdirAll(snapshot, 0700); err != nil {
+		t.Fatalf("MkdirAll: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(snapshot, "file"), []byte("content"), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	f, err := os.Create(indexFile(dir, "snap"))
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	if err := tarindex.WriteIndex(snapshot, f); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+	_ = f.Close()
+	large := strings.Repeat(`{"path": "./gone", "size": 1}`+"\n", 100)
+	handler := &TarHandler{IndexDirectory: dir, MaxManifestSize: int64(len(large) - 1)}
+
+	for _, test := range []struct {
+		manifest string
+		code     int
+	}{
+		{`{"path": "./gone", "size": 1}`, http.StatusOK},
+		{`{"path": "../outside", "size": 1}`, http.StatusBadRequest},
+		{`{"path": "./a/../../outside", "size": 1}`, http.StatusBadRequest},
+		{large, http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		handler.Handler(w, httptest.NewRequest(http.MethodPost, "/snap/sync", strings.NewReader(test.manifest)))
+		if w.Code != test.code {
+			t.Errorf("%.40s: %d", test.manifest, w.Code)
+		}
+		if w.Code == http.StatusOK && !bytes.Contains(w.Body.Bytes(), []byte("./gone\n")) {
+			t.Errorf("%.40s: not deleted", test.manifest)
+		}
+	}
+}
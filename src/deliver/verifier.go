@@ -0,0 +1,114 @@
+package deliver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aurora-is-near/tarserv/src/tarindex"
+)
+
+const indexSuffix = ".taridx"
+
+// IndexVerifier verifies the snapshots of all indexes in IndexDirectory in the background. Snapshots that do not
+// match their index are marked broken and are not served until they verify again.
+type IndexVerifier struct {
+	IndexDirectory string
+	Interval       time.Duration // Time between verification runs.
+	Digest         bool          // Compare file digests, if recorded in the index.
+
+	mutex  sync.RWMutex
+	broken map[string]error // Keyed by index name.
+}
+
+// NewIndexVerifier returns an IndexVerifier that verifies all indexes in indexDirectory every interval.
+func NewIndexVerifier(indexDirectory string, interval time.Duration) *IndexVerifier {
+	return &IndexVerifier{
+		IndexDirectory: indexDirectory,
+		Interval:       interval,
+		broken:         make(map[string]error),
+	}
+}
+
+// Run verifies all indexes, and again after every Interval, until stop is closed.
+func (v *IndexVerifier) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(v.Interval)
+	defer ticker.Stop()
+	for {
+		v.VerifyAll()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// VerifyAll verifies all indexes in IndexDirectory once.
+func (v *IndexVerifier) VerifyAll() {
+	files, err := ioutil.ReadDir(v.IndexDirectory)
+	if err != nil {
+		log.Printf("ERROR: Verify %s: %s", v.IndexDirectory, err)
+		return
+	}
+	broken := make(map[string]error)
+	for _, fi := range files {
+		if !fi.Mode().IsRegular() || !strings.HasSuffix(fi.Name(), indexSuffix) {
+			continue
+		}
+		idxName := strings.TrimSuffix(fi.Name(), indexSuffix)
+		if err := v.verify(idxName); err != nil {
+			log.Printf("ERROR: Verify %s: %s", idxName, err)
+			broken[idxName] = err
+		}
+	}
+	v.mutex.Lock()
+	v.broken = broken
+	v.mutex.Unlock()
+}
+
+// Verify verifies the snapshot of index idxName and records the result.
+func (v *IndexVerifier) Verify(idxName string) error {
+	err := v.verify(idxName)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if err != nil {
+		v.broken[idxName] = err
+	} else {
+		delete(v.broken, idxName)
+	}
+	return err
+}
+
+func (v *IndexVerifier) verify(idxName string) error {
+	f, err := os.Open(indexFile(v.IndexDirectory, idxName))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	var first string
+	report := func(e *tarindex.ListEntry, err error) {
+		if first == "" {
+			first = fmt.Sprintf("%s: %s", e.Name, err)
+		}
+	}
+	mismatches, err := tarindex.VerifyIndex(f, v.Digest, report)
+	if err != nil {
+		return err
+	}
+	if mismatches > 0 {
+		return fmt.Errorf("%d entries do not match, first %s", mismatches, first)
+	}
+	return nil
+}
+
+// Broken returns the reason why the snapshot of index idxName did not match when it was last verified, or nil.
+func (v *IndexVerifier) Broken(idxName string) error {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	return v.broken[idxName]
+}
@@ -0,0 +1,49 @@
+package deliver
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/aurora-is-near/tarserv/src/tarindex"
+)
+
+func TestIndexVerifier(t *testing.T) {
+	tdirName, err := ioutil.TempDir(os.TempDir(), "indexverifier.")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer func() { _ = os.RemoveAll(tdirName) }()
+	snapshot := path.Join(tdirName, "snapshot")
+	if err := os.MkdirAll(snapshot, 0700); err != nil {
+		t.Fatalf("MkdirAll: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(snapshot, "file"), []byte("content"), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	f, err := os.Create(indexFile(tdirName, "snap"))
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	if err := tarindex.WriteIndex(snapshot, f, tarindex.OptStat); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+	_ = f.Close()
+
+	v := NewIndexVerifier(tdirName, 0)
+	v.VerifyAll()
+	if err := v.Broken("snap"); err != nil {
+		t.Errorf("Broken: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(snapshot, "file"), []byte("changed content"), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	v.VerifyAll()
+	if err := v.Broken("snap"); err == nil {
+		t.Error("Not broken after change")
+	}
+	if err := v.Verify("missing"); err == nil || v.Broken("missing") == nil {
+		t.Error("Missing index verified")
+	}
+}
@@ -0,0 +1,86 @@
+package splitting
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrPartMismatch is returned if a part does not match the manifest it is joined with.
+var ErrPartMismatch = errors.New("part does not match manifest")
+
+// checkManifest returns an error if the parts of manifest do not cover the original file.
+func checkManifest(manifest *Manifest) error {
+	var end int64
+	for _, part := range manifest.Parts {
+		if part.Start != end || part.End < part.Start || part.Size != part.End-part.Start+part.Footer {
+			return fmt.Errorf("%w: %s: invalid range", ErrPartMismatch, part.Name)
+		}
+		end = part.End
+	}
+	if end != manifest.Size {
+		return fmt.Errorf("%w: parts end at %d of %d bytes", ErrPartMismatch, end, manifest.Size)
+	}
+	return nil
+}
+
+// joinPart copies part from dir to w without its end-of-archive blocks, after checking size, footer and digest. The
+// part content is written before its digest is known, w must be discarded if joinPart fails.
+func joinPart(dir string, part *Part, w io.Writer) error {
+	f, err := os.Open(filepath.Join(dir, part.Name))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() != part.Size {
+		return fmt.Errorf("%w: %s: size %d, expected %d", ErrPartMismatch, part.Name, fi.Size(), part.Size)
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(w, h), f, part.End-part.Start); err != nil {
+		return err
+	}
+	footer := new(bytes.Buffer)
+	if _, err := io.Copy(io.MultiWriter(footer, h), f); err != nil {
+		return err
+	}
+	if int64(footer.Len()) != part.Footer || !bytes.Equal(footer.Bytes(), make([]byte, part.Footer)) {
+		return fmt.Errorf("%w: %s: invalid end of archive", ErrPartMismatch, part.Name)
+	}
+	if digest := hex.EncodeToString(h.Sum(nil)); digest != part.SHA256 {
+		return fmt.Errorf("%w: %s: digest %s, expected %s", ErrPartMismatch, part.Name, digest, part.SHA256)
+	}
+	return nil
+}
+
+// JoinTar writes the original tar file of the parts described by the manifest manifestFile to w. Parts are read from
+// the directory of the manifest. Size and digest of every part and of the result are checked, w must be discarded if
+// JoinTar fails.
+func JoinTar(manifestFile string, w io.Writer) error {
+	manifest, err := ReadManifest(manifestFile)
+	if err != nil {
+		return err
+	}
+	if err := checkManifest(manifest); err != nil {
+		return err
+	}
+	dir := filepath.Dir(manifestFile)
+	h := sha256.New()
+	for _, part := range manifest.Parts {
+		if err := joinPart(dir, part, io.MultiWriter(w, h)); err != nil {
+			return err
+		}
+	}
+	if digest := hex.EncodeToString(h.Sum(nil)); digest != manifest.SHA256 {
+		return fmt.Errorf("%w: %s: digest %s, expected %s", ErrPartMismatch, manifest.Source, digest, manifest.SHA256)
+	}
+	return nil
+}
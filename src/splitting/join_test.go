@@ -0,0 +1,49 @@
+package splitting
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJoinTar(t *testing.T) {
+	dir := t.TempDir()
+	tarfile := filepath.Join(dir, "data.tar")
+	data := writeTestTar(t, tarfile)
+	out := filepath.Join(dir, "parts")
+	if err := os.Mkdir(out, 0700); err != nil {
+		t.Fatalf("Mkdir: %s", err)
+	}
+	manifest, err := SplitTar(tarfile, out, 4, 0)
+	if err != nil {
+		t.Fatalf("SplitTar: %s", err)
+	}
+	manifestFile := filepath.Join(out, "data.tar"+ManifestSuffix)
+	buf := new(bytes.Buffer)
+	if err := JoinTar(manifestFile, buf); err != nil {
+		t.Fatalf("JoinTar: %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("Joined tar differs from original")
+	}
+
+	f, err := os.OpenFile(filepath.Join(out, manifest.Parts[1].Name), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %s", err)
+	}
+	if _, err := f.WriteAt([]byte("X"), 700); err != nil {
+		t.Fatalf("WriteAt: %s", err)
+	}
+	_ = f.Close()
+	if err := JoinTar(manifestFile, new(bytes.Buffer)); !errors.Is(err, ErrPartMismatch) {
+		t.Errorf("Modified part not detected: %v", err)
+	}
+	if err := os.Truncate(filepath.Join(out, manifest.Parts[2].Name), manifest.Parts[2].Size-512); err != nil {
+		t.Fatalf("Truncate: %s", err)
+	}
+	if err := JoinTar(manifestFile, new(bytes.Buffer)); !errors.Is(err, ErrPartMismatch) {
+		t.Errorf("Truncated part not detected: %v", err)
+	}
+}
@@ -34,26 +34,11 @@ func (pr *PosReader) Read(p []byte) (n int, err error) {
 }
 
 func midpoint2(filename string) (lastbyte int64, err error) {
-	var header *tar.Header
-	var stop int64
-	f, err := os.Open(filename)
-	if err != nil {
-		return 0, err
-	}
-	defer f.Close()
-	stat, err := f.Stat()
+	stat, err := os.Stat(filename)
 	if err != nil {
 		return 0, err
 	}
-	stop = stat.Size() / 2
-	pr := NewPosReader(f)
-	tr := tar.NewReader(pr)
-	for header, err = tr.Next(); err == nil; header, err = tr.Next() {
-		if pr.pos >= stop {
-			return pr.pos + header.Size + tarPadding(header.Size), nil
-		}
-	}
-	return 0, io.ErrShortBuffer
+	return EntryBoundary(filename, stat.Size()/2)
 }
 
 func splitfile(filename string, midpoint int64) error {
@@ -83,6 +68,8 @@ func splitfile(filename string, midpoint int64) error {
 
 // SplitTarMiddle splits a tarfile roughly at it's middle, preserving headers so that each part is a valid tar file.
 // It truncates the input tarfile in place, and copies the remainder into a file called "<tarfile>.part2".
+//
+// Deprecated: Use SplitTar, which does not modify tarfile and ends every part with end-of-archive blocks.
 func SplitTarMiddle(tarfile string) error {
 	mid, err := midpoint2(tarfile)
 	if err != nil {
@@ -0,0 +1,236 @@
+package splitting
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aurora-is-near/tarserv/src/util"
+)
+
+// footerSize is the size of the end-of-archive blocks that are appended to all parts but the last.
+const footerSize = 2 * blocksize
+
+var (
+	// ErrPartSize is returned if an entry does not fit into a part of the requested maximum size.
+	ErrPartSize = errors.New("entry larger than maximum part size")
+	// ErrSplitOptions is returned if neither or both of a number of parts and a maximum size are given.
+	ErrSplitOptions = errors.New("either number of parts or maximum part size required")
+)
+
+// ManifestSuffix is appended to the name of a split tar file to name its manifest.
+const ManifestSuffix = ".parts.json"
+
+// Part is a part of a split tar file.
+type Part struct {
+	Name   string `json:"name"`   // File name of the part, in the directory of the manifest.
+	Start  int64  `json:"start"`  // First byte in the original tar file.
+	End    int64  `json:"end"`    // Byte after the part in the original tar file.
+	Footer int64  `json:"footer"` // Size of the end-of-archive blocks appended to the part.
+	Size   int64  `json:"size"`   // Size of the part file.
+	SHA256 string `json:"sha256"` // Hex encoded digest of the part file.
+}
+
+// Manifest describes how a tar file was split.
+type Manifest struct {
+	Source string  `json:"source"` // File name of the original tar file.
+	Size   int64   `json:"size"`   // Size of the original tar file.
+	SHA256 string  `json:"sha256"` // Hex encoded digest of the original tar file.
+	Parts  []*Part `json:"parts"`
+}
+
+// entryEnds calls endFunc with the byte after every entry of the tar file f, including its content and padding. The
+// last call is made with the size of the file, so that the end-of-archive blocks belong to the last entry. Global
+// extended headers and volume labels belong to the following entry.
+func entryEnds(f io.ReadSeeker, size int64, endFunc func(end int64) error) error {
+	var last int64
+	if _, err := walkEntries(f, func(start, end int64) error {
+		if last > 0 {
+			if err := endFunc(last); err != nil {
+				return err
+			}
+		}
+		last = end
+		return nil
+	}); err != nil {
+		return err
+	}
+	return endFunc(size)
+}
+
+// cutsByCount returns the function that selects the ends of n parts of about the same size.
+func cutsByCount(size int64, n int, cuts *[]int64) func(end int64) error {
+	next := 1
+	return func(end int64) error {
+		if end == size {
+			*cuts = append(*cuts, end)
+			return nil
+		}
+		if next < n && end >= size*int64(next)/int64(n) {
+			*cuts = append(*cuts, end)
+			for next < n && end >= size*int64(next)/int64(n) {
+				next++
+			}
+		}
+		return nil
+	}
+}
+
+// cutsBySize returns the function that selects the ends of parts that are at most maxSize bytes, including the
+// end-of-archive blocks.
+func cutsBySize(size, maxSize int64, cuts *[]int64) func(end int64) error {
+	var start, prev int64
+	return func(end int64) error {
+		footer := int64(footerSize)
+		if end == size {
+			footer = 0
+		}
+		if end-start+footer > maxSize {
+			if prev == start {
+				return fmt.Errorf("%w: %d bytes at %d", ErrPartSize, end-start, start)
+			}
+			*cuts = append(*cuts, prev)
+			start = prev
+			if end-start+footer > maxSize {
+				return fmt.Errorf("%w: %d bytes at %d", ErrPartSize, end-start, start)
+			}
+		}
+		if end == size {
+			*cuts = append(*cuts, end)
+		}
+		prev = end
+		return nil
+	}
+}
+
+// writePart copies part from f to a new file in dir, followed by the end-of-archive blocks of the part. The copied
+// bytes of f are also written to digest. created is false if the file was not created.
+func writePart(f *os.File, dir string, part *Part, digest io.Writer) (created bool, err error) {
+	out, err := util.CreateFile(filepath.Join(dir, part.Name))
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = out.Close() }()
+	h := sha256.New()
+	w := io.MultiWriter(out, h)
+	if _, err := f.Seek(part.Start, io.SeekStart); err != nil {
+		return true, err
+	}
+	if _, err := io.CopyN(io.MultiWriter(w, digest), f, part.End-part.Start); err != nil {
+		return true, err
+	}
+	if _, err := w.Write(make([]byte, part.Footer)); err != nil {
+		return true, err
+	}
+	if err := out.Sync(); err != nil {
+		return true, err
+	}
+	part.Size = part.End - part.Start + part.Footer
+	part.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return true, out.Close()
+}
+
+// SplitTar splits tarfile into parts that are written to dir, either into n parts of about the same size, or into
+// parts of at most maxSize bytes. Parts start and end on entry boundaries, and every part but the last is followed by
+// end-of-archive blocks, so that every part is a valid tar file. Fewer than n parts are written if entries are larger
+// than the parts. The manifest is written to dir as "<tarfile>.parts.json". tarfile is not modified. Global extended
+// headers only apply to the entries of the part that contains them.
+func SplitTar(tarfile, dir string, n int, maxSize int64) (*Manifest, error) {
+	if (n > 0) == (maxSize > 0) {
+		return nil, ErrSplitOptions
+	}
+	f, err := os.Open(tarfile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var cuts []int64
+	cutFunc := cutsByCount(fi.Size(), n, &cuts)
+	if maxSize > 0 {
+		cutFunc = cutsBySize(fi.Size(), maxSize, &cuts)
+	}
+	if err := entryEnds(f, fi.Size(), cutFunc); err != nil {
+		return nil, err
+	}
+	base := filepath.Base(tarfile)
+	manifestFile := filepath.Join(dir, base+ManifestSuffix)
+	if _, err := os.Lstat(manifestFile); err == nil {
+		return nil, fmt.Errorf("%s: %w", manifestFile, os.ErrExist)
+	}
+	manifest := &Manifest{Source: base, Size: fi.Size(), Parts: make([]*Part, len(cuts))}
+	var start int64
+	for i, end := range cuts {
+		manifest.Parts[i] = &Part{Name: fmt.Sprintf("%s.part%d", base, i+1), Start: start, End: end}
+		if end < fi.Size() {
+			manifest.Parts[i].Footer = footerSize
+		}
+		start = end
+	}
+	h := sha256.New()
+	var written []string // Files to remove if splitting fails.
+	err = func() error {
+		for _, part := range manifest.Parts {
+			created, err := writePart(f, dir, part, h)
+			if created {
+				written = append(written, filepath.Join(dir, part.Name))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		manifest.SHA256 = hex.EncodeToString(h.Sum(nil))
+		return WriteManifest(manifestFile, manifest)
+	}()
+	if err != nil {
+		for _, name := range written {
+			_ = os.Remove(name)
+		}
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// WriteManifest writes manifest to the new file filename. The file is removed if it cannot be written completely.
+func WriteManifest(filename string, manifest *Manifest) error {
+	f, err := util.CreateFile(filename)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(manifest)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		_ = os.Remove(filename)
+	}
+	return err
+}
+
+// ReadManifest reads the manifest of a split tar file.
+func ReadManifest(filename string) (*Manifest, error) {
+	d, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	manifest := new(Manifest)
+	if err := json.Unmarshal(d, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
@@ -0,0 +1,122 @@
+package splitting
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestTar writes a tar file with files of different sizes and returns its content.
+func writeTestTar(t *testing.T, name string) []byte {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for i := 0; i < 20; i++ {
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: fmt.Sprintf("file%02d", i), Mode: 0644, Size: int64(i * 700)}
+		if i%5 == 0 {
+			hdr.PAXRecords = map[string]string{"comment": "extended header"}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("WriteHeader: %s", err)
+		}
+		if _, err := tw.Write(bytes.Repeat([]byte{byte('a' + i)}, int(hdr.Size))); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if err := ioutil.WriteFile(name, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	return buf.Bytes()
+}
+
+// readParts checks that all parts are valid tar files and returns the names of their entries and the original
+// content.
+func readParts(t *testing.T, dir string, manifest *Manifest) (names []string, content []byte) {
+	for i, part := range manifest.Parts {
+		d, err := ioutil.ReadFile(filepath.Join(dir, part.Name))
+		if err != nil {
+			t.Fatalf("ReadFile: %s", err)
+		}
+		if int64(len(d)) != part.Size || part.Size != part.End-part.Start+part.Footer {
+			t.Errorf("Part %d: wrong size %d", i, len(d))
+		}
+		tr := tar.NewReader(bytes.NewReader(d))
+		hdr, err := tr.Next()
+		for ; err == nil; hdr, err = tr.Next() {
+			names = append(names, hdr.Name)
+		}
+		if err != io.EOF {
+			t.Errorf("Part %d: %s", i, err)
+		}
+		content = append(content, d[:len(d)-int(part.Footer)]...)
+	}
+	return names, content
+}
+
+func TestSplitTar(t *testing.T) {
+	dir := t.TempDir()
+	tarfile := filepath.Join(dir, "data.tar")
+	data := writeTestTar(t, tarfile)
+
+	out := filepath.Join(dir, "count")
+	if err := os.Mkdir(out, 0700); err != nil {
+		t.Fatalf("Mkdir: %s", err)
+	}
+	manifest, err := SplitTar(tarfile, out, 3, 0)
+	if err != nil {
+		t.Fatalf("SplitTar: %s", err)
+	}
+	if len(manifest.Parts) != 3 || manifest.Size != int64(len(data)) {
+		t.Fatalf("Wrong manifest: %d parts, size %d", len(manifest.Parts), manifest.Size)
+	}
+	names, content := readParts(t, out, manifest)
+	if len(names) != 20 || !bytes.Equal(content, data) {
+		t.Errorf("Parts differ from original: %d entries", len(names))
+	}
+	if read, err := ReadManifest(filepath.Join(out, "data.tar"+ManifestSuffix)); err != nil || len(read.Parts) != 3 ||
+		read.SHA256 != manifest.SHA256 {
+		t.Errorf("ReadManifest: %v", err)
+	}
+	if _, err := SplitTar(tarfile, out, 3, 0); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Manifest overwritten: %v", err)
+	}
+	if d, err := ioutil.ReadFile(tarfile); err != nil || !bytes.Equal(d, data) {
+		t.Errorf("Original modified: %v", err)
+	}
+
+	out = filepath.Join(dir, "size")
+	if err := os.Mkdir(out, 0700); err != nil {
+		t.Fatalf("Mkdir: %s", err)
+	}
+	if manifest, err = SplitTar(tarfile, out, 0, 32*1024); err != nil {
+		t.Fatalf("SplitTar: %s", err)
+	}
+	for i, part := range manifest.Parts {
+		if part.Size > 32*1024 {
+			t.Errorf("Part %d too large: %d", i, part.Size)
+		}
+	}
+	names, content = readParts(t, out, manifest)
+	if len(manifest.Parts) < 3 || len(names) != 20 || !bytes.Equal(content, data) {
+		t.Errorf("Parts differ from original: %d parts, %d entries", len(manifest.Parts), len(names))
+	}
+
+	out = filepath.Join(dir, "small")
+	if err := os.Mkdir(out, 0700); err != nil {
+		t.Fatalf("Mkdir: %s", err)
+	}
+	if _, err := SplitTar(tarfile, out, 0, 8*1024); !errors.Is(err, ErrPartSize) {
+		t.Errorf("Too small parts: %v", err)
+	}
+	if files, _ := ioutil.ReadDir(out); len(files) != 0 {
+		t.Errorf("%d files left after failure", len(files))
+	}
+}
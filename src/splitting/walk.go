@@ -0,0 +1,224 @@
+package splitting
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+var (
+	// ErrHeader is returned for tar headers that cannot be parsed.
+	ErrHeader = errors.New("invalid tar header")
+	// ErrNoBoundary is returned if no entry ends at or after the requested offset.
+	ErrNoBoundary = errors.New("no entry boundary after offset")
+
+	// errBoundaryFound stops walking entries once the boundary has been found.
+	errBoundaryFound = errors.New("found")
+)
+
+// Fields of a tar header block.
+const (
+	sizeField     = 124
+	chksumField   = 148
+	typeflagField = 156
+	fieldEnd      = 12 // Length of the size field.
+	chksumEnd     = 8  // Length of the checksum field.
+
+	gnuIsExtendedField = 482 // Old GNU sparse header: extension blocks follow.
+	extIsExtendedField = 504 // Old GNU sparse extension block: another extension block follows.
+
+	maxExtendedHeaderSize = 1 << 20 // PAX headers are read to find size records.
+)
+
+// parseNumber parses a numeric field in octal or, for large values, in the GNU base-256 format.
+func parseNumber(field []byte) (int64, error) {
+	if len(field) > 0 && field[0]&0x80 != 0 {
+		var n uint64
+		for i, b := range field {
+			if i == 0 {
+				b &= 0x7f
+			}
+			if n>>55 != 0 { // Larger than int64.
+				return 0, fmt.Errorf("%w: number out of range", ErrHeader)
+			}
+			n = n<<8 | uint64(b)
+		}
+		return int64(n), nil
+	}
+	s := string(bytes.Trim(field, " \x00"))
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(s, 8, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: number %q", ErrHeader, s)
+	}
+	return n, nil
+}
+
+// validChecksum returns true if the checksum of block is valid, computed with unsigned or, like some historic tar
+// implementations, with signed bytes.
+func validChecksum(block []byte) bool {
+	chksum, err := parseNumber(block[chksumField : chksumField+chksumEnd])
+	if err != nil {
+		return false
+	}
+	var unsigned, signed int64
+	for i, b := range block {
+		if i >= chksumField && i < chksumField+chksumEnd {
+			b = ' '
+		}
+		unsigned += int64(b)
+		signed += int64(int8(b))
+	}
+	return chksum == unsigned || chksum == signed
+}
+
+// paxSize returns the value of the size record of a PAX extended header.
+func paxSize(records []byte) (size int64, ok bool, err error) {
+	for len(records) > 0 {
+		pos := bytes.IndexByte(records, ' ')
+		if pos <= 0 {
+			return 0, false, fmt.Errorf("%w: PAX record", ErrHeader)
+		}
+		length, err := strconv.Atoi(string(records[:pos]))
+		if err != nil || length <= pos+1 || length > len(records) || records[length-1] != '\n' {
+			return 0, false, fmt.Errorf("%w: PAX record", ErrHeader)
+		}
+		record := records[pos+1 : length-1]
+		if eq := bytes.IndexByte(record, '='); eq >= 0 && string(record[:eq]) == "size" {
+			if size, err = strconv.ParseInt(string(record[eq+1:]), 10, 64); err != nil || size < 0 {
+				return 0, false, fmt.Errorf("%w: PAX size %q", ErrHeader, record[eq+1:])
+			}
+			ok = true
+		}
+		records = records[length:]
+	}
+	return size, ok, nil
+}
+
+// isHeaderOnly returns true for entry types whose content is not stored, regardless of their size field.
+func isHeaderOnly(typeflag byte) bool {
+	switch typeflag {
+	case '1', '2', '3', '4', '5', '6':
+		return true
+	}
+	return false
+}
+
+// walkEntries calls entryFunc with the first byte of every entry of the tar file in r, including its extended
+// headers, and the byte after its content and padding. Headers are read and content is skipped with Seek, so that only
+// headers are read. Global extended headers and volume labels belong to the following entry. PAX extended headers,
+// GNU long names and old GNU sparse files are supported. walkEntries returns the end of the last entry, where the
+// end-of-archive blocks start.
+func walkEntries(r io.ReadSeeker, entryFunc func(start, end int64) error) (int64, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	block := make([]byte, blocksize)
+	var pos, start int64
+	var paxSizeOverride int64 = -1
+	read := func() error {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, block); err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		pos += blocksize
+		return nil
+	}
+	for pos < size {
+		if err := read(); err != nil {
+			return 0, err
+		}
+		if bytes.Count(block, []byte{0}) == len(block) {
+			return pos - blocksize, nil
+		}
+		if !validChecksum(block) {
+			return 0, fmt.Errorf("%w: checksum at %d", ErrHeader, pos-blocksize)
+		}
+		typeflag := block[typeflagField]
+		dataSize, err := parseNumber(block[sizeField : sizeField+fieldEnd])
+		if err != nil {
+			return 0, err
+		}
+		switch typeflag {
+		case 'x', 'X':
+			if dataSize > maxExtendedHeaderSize {
+				return 0, fmt.Errorf("%w: PAX header of %d bytes at %d", ErrHeader, dataSize, pos-blocksize)
+			}
+			records := make([]byte, dataSize)
+			if _, err := io.ReadFull(r, records); err != nil {
+				return 0, err
+			}
+			override, ok, err := paxSize(records)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				paxSizeOverride = override
+			}
+			pos += dataSize + tarPadding(dataSize)
+			continue
+		case 'g', 'L', 'K', 'V':
+			pos += dataSize + tarPadding(dataSize)
+			continue
+		case 'S':
+			for extended := block[gnuIsExtendedField] != 0; extended; extended = block[extIsExtendedField] != 0 {
+				if err := read(); err != nil {
+					return 0, err
+				}
+			}
+		}
+		if paxSizeOverride >= 0 {
+			dataSize = paxSizeOverride
+			paxSizeOverride = -1
+		}
+		if isHeaderOnly(typeflag) {
+			dataSize = 0
+		}
+		pos += dataSize + tarPadding(dataSize)
+		if pos > size {
+			return 0, fmt.Errorf("%w: entry at %d ends after the end of the file", io.ErrUnexpectedEOF, start)
+		}
+		if err := entryFunc(start, pos); err != nil {
+			return 0, err
+		}
+		start = pos
+	}
+	if start != pos {
+		return 0, fmt.Errorf("%w: no entry after extended header at %d", io.ErrUnexpectedEOF, start)
+	}
+	return pos, nil
+}
+
+// EntryBoundary returns the end of the first entry of tarfile that ends at or after offset, so that tarfile can be
+// split there. Only headers are read.
+func EntryBoundary(tarfile string, offset int64) (int64, error) {
+	f, err := os.Open(tarfile)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+	var boundary int64
+	if _, err := walkEntries(f, func(start, end int64) error {
+		if end >= offset {
+			boundary = end
+			return errBoundaryFound
+		}
+		return nil
+	}); err == errBoundaryFound {
+		return boundary, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("%w: %d", ErrNoBoundary, offset)
+}
@@ -0,0 +1,171 @@
+package splitting
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setField writes an octal number to a header field.
+func setField(block []byte, pos, length int, n int64) {
+	copy(block[pos:pos+length], fmt.Sprintf("%0*o", length-1, n))
+}
+
+// oldGNUSparse returns an old GNU sparse entry with six data regions of one block, two of them in an extension block.
+func oldGNUSparse() []byte {
+	const regions = 6
+	block := make([]byte, blocksize)
+	copy(block, "sparse")
+	setField(block, 100, 8, 0644)
+	setField(block, sizeField, fieldEnd, regions*blocksize)
+	setField(block, 136, 12, 1600000000)
+	block[typeflagField] = 'S'
+	copy(block[257:], "ustar  \x00")
+	ext := make([]byte, blocksize)
+	for i := 0; i < regions; i++ {
+		b, pos := block, 386+i*24
+		if i >= 4 {
+			b, pos = ext, (i-4)*24
+		}
+		setField(b, pos, 12, int64(i)*4096)
+		setField(b, pos+12, 12, blocksize)
+	}
+	block[gnuIsExtendedField] = 1
+	setField(block, 483, 12, regions*4096)
+	var sum int64
+	copy(block[chksumField:chksumField+chksumEnd], "        ")
+	for _, b := range block {
+		sum += int64(b)
+	}
+	copy(block[chksumField:], fmt.Sprintf("%06o\x00 ", sum))
+	entry := append(block, ext...)
+	return append(entry, bytes.Repeat([]byte{'s'}, int(regions*blocksize))...)
+}
+
+// writeWalkTar writes a tar file with GNU long names, PAX headers, a global header and an old GNU sparse file.
+func writeWalkTar(t *testing.T, name string) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	headers := []*tar.Header{
+		{Typeflag: tar.TypeXGlobalHeader, Name: "global", PAXRecords: map[string]string{"comment": "test"}},
+		{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755},
+		{Typeflag: tar.TypeReg, Name: "dir/" + strings.Repeat("l", 200), Mode: 0644, Size: 700, Format: tar.FormatGNU},
+		{Typeflag: tar.TypeReg, Name: "dir/pax", Mode: 0644, Size: 1024,
+			PAXRecords: map[string]string{"SCHILY.xattr.user.test": "value"}},
+		{Typeflag: tar.TypeSymlink, Name: "dir/symlink", Linkname: strings.Repeat("t", 200), Format: tar.FormatGNU},
+	}
+	for _, hdr := range headers {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("WriteHeader: %s", err)
+		}
+		if _, err := tw.Write(bytes.Repeat([]byte{'x'}, int(hdr.Size))); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		t.Fatalf("Flush: %s", err)
+	}
+	buf.Write(oldGNUSparse())
+	tw = tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "last", Mode: 0644, Size: 10}); err != nil {
+		t.Fatalf("WriteHeader: %s", err)
+	}
+	if _, err := tw.Write(bytes.Repeat([]byte{'x'}, 10)); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if err := ioutil.WriteFile(name, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+}
+
+func TestWalkEntries(t *testing.T) {
+	tarfile := filepath.Join(t.TempDir(), "data.tar")
+	writeWalkTar(t, tarfile)
+	f, err := os.Open(tarfile)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	// Entry ends according to archive/tar, which reads every byte.
+	var expected []int64
+	pr := NewPosReader(f)
+	tr := tar.NewReader(pr)
+	for hdr, err := tr.Next(); err != io.EOF; hdr, err = tr.Next() {
+		if err != nil {
+			t.Fatalf("Next: %s", err)
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+			t.Fatalf("Copy: %s", err)
+		}
+		expected = append(expected, pr.pos+tarPadding(pr.pos))
+	}
+
+	var ends []string
+	var start int64
+	end, err := walkEntries(f, func(entryStart, entryEnd int64) error {
+		if entryStart != start {
+			t.Errorf("Entry starts at %d, expected %d", entryStart, start)
+		}
+		start = entryEnd
+		ends = append(ends, fmt.Sprint(entryEnd))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walkEntries: %s", err)
+	}
+	if s, e := strings.Join(ends, " "), strings.Trim(fmt.Sprint(expected), "[]"); s != e || end != expected[len(expected)-1] {
+		t.Errorf("Wrong entry ends %s, expected %s", s, e)
+	}
+
+	if boundary, err := EntryBoundary(tarfile, expected[2]-1); err != nil || boundary != expected[2] {
+		t.Errorf("EntryBoundary: %d, %v", boundary, err)
+	}
+	if boundary, err := EntryBoundary(tarfile, expected[2]); err != nil || boundary != expected[2] {
+		t.Errorf("EntryBoundary on boundary: %d, %v", boundary, err)
+	}
+	if _, err := EntryBoundary(tarfile, end+1); !errors.Is(err, ErrNoBoundary) {
+		t.Errorf("EntryBoundary after last entry: %v", err)
+	}
+	for _, size := range []int64{expected[3] - blocksize, expected[2] - blocksize} { // Long link name and content.
+		if err := os.Truncate(tarfile, size); err != nil {
+			t.Fatalf("Truncate: %s", err)
+		}
+		if _, err := EntryBoundary(tarfile, end); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Truncated tar file not detected at %d: %v", size, err)
+		}
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	for _, test := range []struct {
+		field string
+		n     int64
+		err   bool
+	}{
+		{"00000001750\x00", 1000, false},
+		{"     1750 \x00", 1000, false},
+		{"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00", 0, false},
+		{"\x80\x00\x00\x00\x00\x00\x00\x02\x00\x00\x00\x00", 1 << 33, false},
+		{"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff", 0, true},
+		{"0000000175x\x00", 0, true},
+	} {
+		n, err := parseNumber([]byte(test.field))
+		if n != test.n || (err != nil) != test.err {
+			t.Errorf("%q: %d, %v", test.field, n, err)
+		}
+	}
+}
@@ -10,13 +10,26 @@ var (
 	ErrMissingHeader = errors.New("missing header")
 )
 
-func listToChan(dir string) (list *lister) {
+func listToChan(dir string, options []Option) (list *lister) {
 	dir = path.Clean(dir)
-	list = newLister()
+	list = newLister(newListOptions(options))
+	list.root = dir
 	go func() {
-		defer list.closeChan()
-		if err := list.addDir(dir); err != nil {
-			list.c <- err
+		defer close(list.c)
+		var rules *ignoreRules
+		exclude, err := compilePatterns(list.options.exclude)
+		if err == nil && len(exclude) > 0 {
+			rules = &ignoreRules{patterns: exclude}
+		}
+		if err == nil && len(list.options.include) > 0 {
+			list.include, err = compilePatterns(list.options.include)
+		}
+		if err != nil {
+			list.send(err)
+			return
+		}
+		if err := list.addDir(list.openDir(dir), rules); err != nil {
+			list.send(err)
 		}
 	}()
 	return list
@@ -26,14 +39,14 @@ func listToChan(dir string) (list *lister) {
 // The channel is closed after listing has been completed.
 // The channel will contain either *ListEntry or error entries.
 //goland:noinspection GoUnusedExportedFunction
-func ListToChan(dir string) (entries chan interface{}) {
-	list := listToChan(dir)
+func ListToChan(dir string, options ...Option) (entries chan interface{}) {
+	list := listToChan(dir, options)
 	return list.c
 }
 
 // ListToFunc produces a flow of list entries that are given to entryFunc for processing.
-func ListToFunc(dir string, entryFunc func(*ListEntry) error) error {
-	list := listToChan(dir)
+func ListToFunc(dir string, entryFunc func(*ListEntry) error, options ...Option) error {
+	list := listToChan(dir, options)
 	for m := range list.c {
 		switch n := m.(type) {
 		case *ListEntry:
@@ -67,8 +80,67 @@ func IndexHeader(r io.Reader) (size int64, dir string, err error) {
 	return e.Size, e.Name, nil
 }
 
+// IndexMetadata reads the header of the index file and returns the metadata of the index.
+func IndexMetadata(r io.Reader) (Metadata, error) {
+	if _, _, err := IndexHeader(r); err != nil {
+		return nil, err
+	}
+	return newIndexScanner(r).readHeaderMeta()
+}
+
+// ReadIndex calls entryFunc for every entry of the index in r, in order, with its metadata and the bytes it occupies
+// in the tar stream. The filesystem is not accessed. Like IndexHeader, it reads from the start of r if r is an
+// io.ReadSeeker. ReadIndex stops and returns the first error of entryFunc.
+func ReadIndex(r io.Reader, entryFunc func(*ListEntry) error) error {
+	s, _, err := scanIndex(r)
+	if err != nil {
+		return err
+	}
+	for {
+		e, err := s.next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := entryFunc(e); err != nil {
+			return err
+		}
+	}
+}
+
 // WriteIndex writes an index file. w should be an io.WriteSeeker if possible.
-func WriteIndex(dir string, w io.Writer) error {
+// The include and exclude options are recorded in the metadata of the index.
+func WriteIndex(dir string, w io.Writer, options ...Option) error {
+	return writeIndex(dir, newListOptions(options).metadata(), w, func(entryFunc func(*ListEntry) error) error {
+		return ListToFunc(dir, entryFunc, options...)
+	})
+}
+
+// WriteRootsIndex writes an index of several source directories, whose content is written to the tar stream under
+// their prefixes. Directories must not contain each other, prefixes must be unique. The roots are recorded in the
+// metadata of the index. Options apply to every root, patterns are relative to each root.
+func WriteRootsIndex(roots []Root, w io.Writer, options ...Option) error {
+	roots, err := cleanRoots(roots)
+	if err != nil {
+		return err
+	}
+	meta := newListOptions(options).metadata()
+	for key, value := range rootsMetadata(roots) {
+		meta[key] = value
+	}
+	return writeIndex(roots[0].Dir, meta, w, func(entryFunc func(*ListEntry) error) error {
+		for _, root := range roots {
+			if err := ListToFunc(root.Dir, entryFunc, options...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeIndex writes the index of the entries produced by list, with dir in the header and headerMeta following it.
+func writeIndex(dir string, headerMeta Metadata, w io.Writer, list func(entryFunc func(*ListEntry) error) error) error {
 	var offset int64
 	var fileHdr, hdr *BinaryEntry
 
@@ -76,6 +148,7 @@ func WriteIndex(dir string, w io.Writer) error {
 		Type: EntryTypeHeader,
 		Name: dir,
 	}).BinaryEntry(0)
+	headerMetaEntries := headerMeta.BinaryEntries(EntryTypeHeaderMeta)
 
 	entryFunc := func(e *ListEntry) error {
 		if fileHdr != nil {
@@ -83,6 +156,18 @@ func WriteIndex(dir string, w io.Writer) error {
 				return err
 			}
 			fileHdr = nil
+			for _, bin := range headerMetaEntries {
+				if _, err := w.Write(bin[:]); err != nil {
+					return err
+				}
+			}
+		}
+		if len(e.Meta) > 0 {
+			for _, bin := range e.Meta.BinaryEntries(EntryTypeMeta) {
+				if _, err := w.Write(bin[:]); err != nil {
+					return err
+				}
+			}
 		}
 		offset, hdr = e.BinaryEntry(offset)
 		if _, err := w.Write(hdr[:]); err != nil {
@@ -90,7 +175,7 @@ func WriteIndex(dir string, w io.Writer) error {
 		}
 		return nil
 	}
-	err := ListToFunc(dir, entryFunc)
+	err := list(entryFunc)
 	if err == nil {
 		if w2, ok := w.(io.WriteSeeker); ok {
 			if _, err := w2.Seek(0, io.SeekStart); err != nil {
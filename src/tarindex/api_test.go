@@ -1,8 +1,15 @@
 package tarindex
 
 import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -51,3 +58,166 @@ func TestLister(t *testing.T) {
 		t.Fatalf("ListToFunc: %s", err)
 	}
 }
+
+func TestReadIndex(t *testing.T) {
+	dir := writeTestTree(t)
+	f, err := ioutil.TempFile(t.TempDir(), "index.")
+	if err != nil {
+		t.Fatalf("TempFile: %s", err)
+	}
+	defer func() { _ = f.Close() }()
+	if err := WriteIndex(dir, f, OptStat); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+	size, _, err := IndexHeader(f)
+	if err != nil {
+		t.Fatalf("IndexHeader: %s", err)
+	}
+	var entries int
+	var offset int64
+	if err := ReadIndex(f, func(e *ListEntry) error {
+		if e.FirstByte != offset || e.LastByte-e.FirstByte != e.Size {
+			t.Errorf("%s: bytes %d-%d after offset %d, size %d", e.Name, e.FirstByte, e.LastByte, offset, e.Size)
+		}
+		if e.Type == EntryTypeFile && e.Meta[MetaSize] == "" {
+			t.Errorf("%s: no metadata", e.Name)
+		}
+		offset = e.LastByte
+		entries++
+		return nil
+	}); err != nil {
+		t.Fatalf("ReadIndex: %s", err)
+	}
+	if entries == 0 || offset+tarFooterSize != size {
+		t.Errorf("%d entries end at %d, header size %d", entries, offset, size)
+	}
+	stop := errors.New("stop")
+	entries = 0
+	if err := ReadIndex(f, func(e *ListEntry) error {
+		entries++
+		return stop
+	}); err != stop || entries != 1 {
+		t.Errorf("ReadIndex did not stop: %v after %d entries", err, entries)
+	}
+	if s := EntryTypeHardlink.String(); s != "hardlink" {
+		t.Errorf("EntryTypeHardlink: %s", s)
+	}
+}
+
+func TestReadHeaderlessIndex(t *testing.T) {
+	dir := writeTestTree(t)
+	idx := new(bytes.Buffer)
+	if err := WriteIndex(dir, idx); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+	headerless := idx.Bytes()[binaryEntrySize:]
+	for EntryType(headerless[binaryTypePos]) == EntryTypeHeaderMeta {
+		headerless = headerless[binaryEntrySize:]
+	}
+	if _, _, err := IndexHeader(bytes.NewReader(headerless)); err != ErrMissingHeader {
+		t.Fatalf("IndexHeader: %v", err)
+	}
+	readEntries := func(index []byte) []string {
+		entries := make([]string, 0)
+		if err := ReadIndex(bytes.NewReader(index), func(e *ListEntry) error {
+			entries = append(entries, fmt.Sprintf("%s %d-%d", e.Name, e.FirstByte, e.LastByte))
+			return nil
+		}); err != nil {
+			t.Fatalf("ReadIndex: %s", err)
+		}
+		return entries
+	}
+	entries := readEntries(idx.Bytes())
+	if headerlessEntries := readEntries(headerless); !reflect.DeepEqual(headerlessEntries, entries) {
+		t.Errorf("Headerless index: %v, expected %v", headerlessEntries, entries)
+	}
+	if err := os.Rename(dir, dir+".moved"); err != nil {
+		t.Fatalf("Rename: %s", err)
+	}
+	defer func() { _ = os.RemoveAll(dir + ".moved") }()
+	if n, err := VerifyIndex(bytes.NewReader(headerless), false, nil); err != nil || n != len(entries) {
+		t.Errorf("VerifyIndex: %d of %d entries, %v", n, len(entries), err)
+	}
+}
+
+func TestWriteRootsIndex(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"db/sub", "app"} {
+		if err := os.MkdirAll(path.Join(dir, name), 0755); err != nil {
+			t.Fatalf("MkdirAll: %s", err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "db", "sub", "file"), []byte("db"), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "app", "conf"), []byte("app"), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	f, err := ioutil.TempFile(t.TempDir(), "index.")
+	if err != nil {
+		t.Fatalf("TempFile: %s", err)
+	}
+	defer func() { _ = f.Close() }()
+	if err := WriteRootsIndex([]Root{{Dir: path.Join(dir, "db"), Prefix: "db"}, {Dir: path.Join(dir, "app"), Prefix: "./etc/config/"}}, f); err != nil {
+		t.Fatalf("WriteRootsIndex: %s", err)
+	}
+	buf := new(bytes.Buffer)
+	ir, err := NewIndexReader(f, buf, nil)
+	if err != nil {
+		t.Fatalf("NewIndexReader: %s", err)
+	}
+	if _, err := ir.SeekAndWrite("./etc/config", 0, 0); err != nil {
+		t.Fatalf("SeekAndWrite: %s", err)
+	}
+	var names []string
+	tr := tar.NewReader(buf)
+	for hdr, err := tr.Next(); err == nil; hdr, err = tr.Next() {
+		names = append(names, hdr.Name)
+	}
+	if s := strings.Join(names, " "); s != "./etc/config/ ./etc/config/conf" {
+		t.Errorf("Wrong entries after seek: %s", s)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd: %s", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Chdir: %s", err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+	idx := new(bytes.Buffer)
+	if err := WriteRootsIndex([]Root{{Dir: "db", Prefix: "./a"}, {Dir: "./app/", Prefix: "./b"}}, idx); err != nil {
+		t.Fatalf("WriteRootsIndex with relative roots: %s", err)
+	}
+	names = nil
+	_, root, err := IndexHeader(bytes.NewReader(idx.Bytes()))
+	if err != nil {
+		t.Fatalf("IndexHeader: %s", err)
+	}
+	meta, err := IndexMetadata(bytes.NewReader(idx.Bytes()))
+	if err != nil {
+		t.Fatalf("IndexMetadata: %s", err)
+	}
+	fixPath := IndexFixPath(root, meta)
+	if err := ReadIndex(bytes.NewReader(idx.Bytes()), func(e *ListEntry) error {
+		names = append(names, path.Clean(fixPath(e.Name)))
+		return nil
+	}); err != nil {
+		t.Fatalf("ReadIndex: %s", err)
+	}
+	if s := strings.Join(names, " "); s != "a a/sub a/sub/file b b/conf" {
+		t.Errorf("Wrong entries with relative roots: %s", s)
+	}
+
+	for _, roots := range [][]Root{
+		nil,
+		{{Dir: ".", Prefix: "a"}, {Dir: path.Join(dir, "db"), Prefix: "b"}},
+		{{Dir: dir, Prefix: "a"}, {Dir: path.Join(dir, "db"), Prefix: "b"}},
+		{{Dir: path.Join(dir, "db"), Prefix: "a"}, {Dir: path.Join(dir, "app"), Prefix: "./a/"}},
+	} {
+		if err := WriteRootsIndex(roots, ioutil.Discard); !errors.Is(err, ErrRoots) {
+			t.Errorf("Invalid roots %v accepted: %v", roots, err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package tarindex
+
+import (
+	"bytes"
+)
+
+// ChangedFileName is the name of the trailer file that lists files whose size changed since indexing. It only lists
+// files written in the same request, see IndexReader.KeepIndexedSize.
+const ChangedFileName = ".changed"
+
+const (
+	changedContentSize = 4096 // Fixed size, so that the size of the tar stream is known in advance.
+	changedTruncated   = "...\n"
+)
+
+var changedTrailerSize = PostfixFileSize(make([]byte, changedContentSize))
+
+// changedContent returns the content of the trailer file, one path per line, padded with newlines. Paths that do not
+// fit are replaced by a line "...".
+func changedContent(names []string, fixPath func(string) string) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, changedContentSize))
+	for _, name := range names {
+		line := fixPath(name) + "\n"
+		if buf.Len()+len(line) > changedContentSize-len(changedTruncated) {
+			buf.WriteString(changedTruncated)
+			break
+		}
+		buf.WriteString(line)
+	}
+	content := buf.Bytes()
+	for len(content) < changedContentSize {
+		content = append(content, '\n')
+	}
+	return content
+}
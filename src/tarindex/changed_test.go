@@ -0,0 +1,120 @@
+package tarindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSizeChanged(t *testing.T) {
+	tdirName := writeTestTree(t)
+	defer func() { _ = os.RemoveAll(tdirName) }()
+	idx, err := ioutil.TempFile(os.TempDir(), "tarchanged.idx.")
+	if err != nil {
+		t.Fatalf("TempFile: %s", err)
+	}
+	defer func() { _ = os.Remove(idx.Name()); _ = idx.Close() }()
+	if err := WriteIndex(tdirName, idx); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+	grown, shrunk := path.Join(tdirName, "dir1", "file11"), path.Join(tdirName, "dir2", "file12")
+	if err := ioutil.WriteFile(grown, bytes.Repeat([]byte{1}, 20000), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	if err := os.Truncate(shrunk, 10); err != nil {
+		t.Fatalf("Truncate: %s", err)
+	}
+
+	ir, err := NewIndexReader(idx, ioutil.Discard, nil)
+	if err != nil {
+		t.Fatalf("NewIndexReader: %s", err)
+	}
+	if _, err := ir.SeekAndWrite("", 0, 0); !errors.Is(err, ErrSizeChanged) {
+		t.Errorf("Changed size not detected: %v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	ir, err = NewIndexReader(idx, buf, nil)
+	if err != nil {
+		t.Fatalf("NewIndexReader: %s", err)
+	}
+	ir.KeepIndexedSize()
+	if _, err := ir.SeekAndWrite("", 0, 0); err != nil {
+		t.Fatalf("SeekAndWrite: %s", err)
+	}
+	if int64(buf.Len()) != ir.Size() {
+		t.Errorf("Size %d != %d", buf.Len(), ir.Size())
+	}
+	if len(ir.Writer().Changed()) != 2 {
+		t.Errorf("Changed: %v", ir.Writer().Changed())
+	}
+	tr := tar.NewReader(buf)
+	var trailer []byte
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next: %s", err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("ReadAll: %s", err)
+		}
+		switch path.Base(hdr.Name) {
+		case "file11":
+			if hdr.Size != paddedTarBlockSize(11*11*11*7) || !bytes.Equal(data, bytes.Repeat([]byte{1}, len(data))) {
+				t.Errorf("Grown file: %d", hdr.Size)
+			}
+		case "file12":
+			if hdr.Size != paddedTarBlockSize(12*12*12*7) || !bytes.Equal(data[10:], make([]byte, len(data)-10)) {
+				t.Errorf("Shrunk file: %d", hdr.Size)
+			}
+		case ChangedFileName:
+			trailer = data
+		}
+	}
+	if lines := strings.Fields(string(trailer)); len(lines) != 2 || path.Base(lines[0]) != "file11" || path.Base(lines[1]) != "file12" {
+		t.Errorf("Trailer: %q", lines)
+	}
+}
+
+func TestShrinkWhileReading(t *testing.T) {
+	dir := t.TempDir()
+	name := path.Join(dir, "file")
+	content := bytes.Repeat([]byte{1}, 3*directIOAlign+10)
+	for _, directIOSize := range []int64{0, 1} {
+		if err := ioutil.WriteFile(name, content, 0600); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("Stat: %s", err)
+		}
+		entry := mkListEntry(dir, fi)
+		buf := new(bytes.Buffer)
+		tarW := &TarWriter{w: buf, DirectIOSize: directIOSize, NoZeroCopy: true, KeepIndexedSize: true}
+		src := tarW.openEntry(entry, 0)
+		if err := os.Truncate(name, directIOAlign); err != nil {
+			t.Fatalf("Truncate: %s", err)
+		}
+		n, err := tarW.writeFileEntry(entry, src, 0, -1)
+		src.close()
+		if err != nil {
+			t.Fatalf("DirectIOSize %d: writeFileEntry: %s", directIOSize, err)
+		}
+		if n != tarHeaderSize+paddedTarBlockSize(int64(len(content))) {
+			t.Errorf("DirectIOSize %d: %d bytes written", directIOSize, n)
+		}
+		body, kept := buf.Bytes()[tarHeaderSize:], directIOAlign
+		if !bytes.Equal(body[:kept], content[:kept]) || !bytes.Equal(body[kept:], make([]byte, len(body)-kept)) {
+			t.Errorf("DirectIOSize %d: content not padded with zeros", directIOSize)
+		}
+	}
+}
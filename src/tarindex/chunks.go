@@ -0,0 +1,212 @@
+package tarindex
+
+import (
+	"io"
+	"path"
+)
+
+// Chunk is a range of the tar stream that starts and ends on entry boundaries.
+type Chunk struct {
+	Start int64  `json:"start"` // First byte.
+	End   int64  `json:"end"`   // Byte after the chunk.
+	First string `json:"first"` // Path of the first entry in the tar stream.
+}
+
+// hardlinkTargets maps the paths of the targets of hard links in the tar stream to their first byte.
+type hardlinkTargets struct {
+	linked map[string]bool  // Paths of all targets, or nil to record every file.
+	starts map[string]int64 // First byte of the targets read so far.
+}
+
+// hardlinkTargets collects the targets of the hard links in the remaining entries of the index, which is read a second
+// time for Chunks and Parts. If the index cannot be read twice, the first byte of every file is recorded instead.
+func (ir *IndexReader) hardlinkTargets() (*hardlinkTargets, error) {
+	targets := &hardlinkTargets{starts: make(map[string]int64)}
+	rs, ok := ir.s.r.(io.ReadSeeker)
+	if !ok {
+		return targets, nil
+	}
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return targets, nil
+	}
+	targets.linked = make(map[string]bool)
+	s := &indexScanner{r: rs, offset: ir.s.offset, pending: ir.s.pending}
+	for {
+		e, err := s.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if e.Type == EntryTypeHardlink {
+			targets.linked[path.Clean(ir.w.fixPath(e.Meta[MetaLinkPath]))] = true
+		}
+	}
+	if _, err := rs.Seek(pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// add records e if it is the target of a hard link. If e is a hard link, it returns the first byte of its target.
+func (targets *hardlinkTargets) add(e *ListEntry, fixPath func(string) string) (int64, bool) {
+	switch e.Type {
+	case EntryTypeFile:
+		name := path.Clean(fixPath(e.Name))
+		if targets.linked == nil || targets.linked[name] {
+			targets.starts[name] = e.FirstByte
+		}
+	case EntryTypeHardlink:
+		start, ok := targets.starts[path.Clean(fixPath(e.Meta[MetaLinkPath]))]
+		return start, ok
+	}
+	return 0, false
+}
+
+// Chunks splits the tar stream into chunks that start and end on entry boundaries. Every chunk ends with the first
+// entry that reaches size bytes, the last chunk also contains the postfix files and the end of the archive.
+// Concatenated, the chunks are the complete tar stream. Hard links are kept in the chunk of their target, joining the
+// chunks in between, so that every chunk can be extracted on its own. Chunks requires the size of the tar stream to
+// be known and cannot be combined with seeking.
+func (ir *IndexReader) Chunks(size int64) ([]*Chunk, error) {
+	if ir.totalSize == 0 {
+		return nil, ErrMissingHeader
+	}
+	if ir.noMoreSeek {
+		return nil, ErrNoSeek
+	}
+	ir.noMoreSeek = true
+	targets, err := ir.hardlinkTargets()
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]*Chunk, 0)
+	var current *Chunk
+	for {
+		e, err := ir.s.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if start, ok := targets.add(e, ir.w.fixPath); ok {
+			for chunks[len(chunks)-1].Start > start {
+				chunks = chunks[:len(chunks)-1]
+			}
+			current = chunks[len(chunks)-1]
+		}
+		if current == nil {
+			current = &Chunk{Start: e.FirstByte, First: path.Clean(ir.w.fixPath(e.Name))}
+			chunks = append(chunks, current)
+		}
+		current.End = e.LastByte
+		if current.End-current.Start >= size {
+			current = nil
+		}
+	}
+	if current == nil && len(chunks) > 0 {
+		// Postfix files and the end of the archive alone are not worth a chunk.
+		current = chunks[len(chunks)-1]
+	} else if current == nil {
+		current = &Chunk{}
+		chunks = append(chunks, current)
+	}
+	current.End = ir.totalSize
+	return chunks, nil
+}
+
+// Parts splits the entries of the tar stream into n contiguous groups of about the same size, for WritePart. An entry
+// belongs to the part that contains its first byte if the entries were cut into n equal ranges, so parts are empty
+// if single entries span them. Hard links are kept in the part of their target, with the entries in between, so that
+// every part can be extracted on its own. Parts requires the size of the tar stream to be known and cannot be
+// combined with seeking.
+func (ir *IndexReader) Parts(n int) ([]*Chunk, error) {
+	if ir.totalSize == 0 {
+		return nil, ErrMissingHeader
+	}
+	if ir.noMoreSeek {
+		return nil, ErrNoSeek
+	}
+	ir.noMoreSeek = true
+	entryBytes := ir.totalSize - ir.postfixSize() - tarFooterSize
+	targets, err := ir.hardlinkTargets()
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]*Chunk, n)
+	for {
+		e, err := ir.s.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		i := int(e.FirstByte * int64(n) / entryBytes)
+		if i >= n {
+			i = n - 1
+		}
+		if start, ok := targets.add(e, ir.w.fixPath); ok {
+			j := i
+			for parts[j] == nil || parts[j].Start > start {
+				parts[j] = nil
+				j--
+			}
+			i = j
+		}
+		if parts[i] == nil {
+			parts[i] = &Chunk{Start: e.FirstByte, First: path.Clean(ir.w.fixPath(e.Name))}
+		}
+		parts[i].End = e.LastByte
+	}
+	var end int64
+	for i := range parts {
+		if parts[i] == nil {
+			parts[i] = &Chunk{Start: end, End: end}
+		}
+		end = parts[i].End
+	}
+	return parts, nil
+}
+
+// PartSize returns the size of the tar stream that WritePart produces for part.
+func (ir *IndexReader) PartSize(part *Chunk) int64 {
+	return part.End - part.Start + ir.postfixSize() + tarFooterSize
+}
+
+// WritePart writes the entries of part, as returned by Parts, as a complete tar stream that is followed by the postfix
+// files and the end of the archive. part must start and end on entry boundaries.
+func (ir *IndexReader) WritePart(part *Chunk) (int64, error) {
+	if part.Start == part.End {
+		if ir.noMoreSeek {
+			return 0, ErrNoSeek
+		}
+		ir.noMoreSeek = true
+		return ir.writeTrailer()
+	}
+	if err := ir.SeekByte(part.Start); err != nil {
+		return 0, err
+	}
+	ir.noMoreSeek = true
+	if ir.skipBytes != 0 {
+		return 0, ErrSkipBoundary
+	}
+	var written int64
+	if ir.seekEntry != nil {
+		n, err := ir.w.WriteEntry(ir.seekEntry, 0, -1)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		ir.seekEntry = nil
+	}
+	if remaining := part.End - ir.s.offset; remaining > 0 {
+		n, err := ir.writeEntries(remaining)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	n, err := ir.writeTrailer()
+	return written + n, err
+}
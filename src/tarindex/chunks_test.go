@@ -0,0 +1,133 @@
+package tarindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// checkLinks returns the number of hard links in the tar stream b, and an error if one of them does not refer to an
+// earlier file of b.
+func checkLinks(b []byte) (int, error) {
+	files := make(map[string]bool)
+	links := 0
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return links, nil
+		} else if err != nil {
+			return links, err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			files[path.Clean(hdr.Name)] = true
+		case tar.TypeLink:
+			links++
+			if !files[path.Clean(hdr.Linkname)] {
+				return links, fmt.Errorf("target %s of %s missing", hdr.Linkname, hdr.Name)
+			}
+		}
+	}
+}
+
+func TestHardlinkChunks(t *testing.T) {
+	tdirName := t.TempDir()
+	if err := ioutil.WriteFile(path.Join(tdirName, "e"), bytes.Repeat([]byte{1}, 5000), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	for _, prefix := range []string{"d", "f", "h"} { // Before the file, between file and link, after the link.
+		for i := 0; i < 5; i++ {
+			name := path.Join(tdirName, fmt.Sprintf("%s%02d", prefix, i))
+			if err := ioutil.WriteFile(name, bytes.Repeat([]byte{2}, 1000), 0600); err != nil {
+				t.Fatalf("WriteFile: %s", err)
+			}
+		}
+	}
+	if err := os.Link(path.Join(tdirName, "e"), path.Join(tdirName, "g")); err != nil {
+		t.Skipf("Link: %s", err)
+	}
+	idx, err := os.Create(path.Join(t.TempDir(), "index"))
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	defer func() { _ = idx.Close() }()
+	if err := WriteIndex(tdirName, idx); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+	newReader := func(w io.Writer) *IndexReader {
+		if _, err := idx.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("Seek: %s", err)
+		}
+		ir, err := NewIndexReader(idx, w, nil)
+		if err != nil {
+			t.Fatalf("NewIndexReader: %s", err)
+		}
+		return ir
+	}
+
+	ir := newReader(ioutil.Discard)
+	targets, err := ir.hardlinkTargets()
+	if err != nil {
+		t.Fatalf("hardlinkTargets: %s", err)
+	}
+	for e, err := ir.s.next(); err != io.EOF; e, err = ir.s.next() {
+		if err != nil {
+			t.Fatalf("next: %s", err)
+		}
+		targets.add(e, ir.w.fixPath)
+	}
+	if _, ok := targets.starts["e"]; !ok || len(targets.starts) != 1 {
+		t.Errorf("Recorded targets: %v", targets.starts)
+	}
+
+	full := new(bytes.Buffer)
+	if _, err := newReader(full).SeekAndWrite("", 0, 0); err != nil {
+		t.Fatalf("SeekAndWrite: %s", err)
+	}
+	chunks, err := newReader(ioutil.Discard).Chunks(2048)
+	if err != nil {
+		t.Fatalf("Chunks: %s", err)
+	}
+	var links int
+	var end int64
+	for i, chunk := range chunks {
+		if chunk.Start != end {
+			t.Errorf("Chunk %d starts at %d, expected %d", i, chunk.Start, end)
+		}
+		end = chunk.End
+		n, err := checkLinks(full.Bytes()[chunk.Start:chunk.End])
+		if err != nil {
+			t.Errorf("Chunk %d: %s", i, err)
+		}
+		links += n
+	}
+	if links != 1 || len(chunks) < 3 || end != int64(full.Len()) {
+		t.Errorf("%d chunks with %d links end at %d", len(chunks), links, end)
+	}
+
+	parts, err := newReader(ioutil.Discard).Parts(4)
+	if err != nil {
+		t.Fatalf("Parts: %s", err)
+	}
+	links = 0
+	for i, part := range parts {
+		buf := new(bytes.Buffer)
+		if _, err := newReader(buf).WritePart(part); err != nil {
+			t.Fatalf("WritePart %d: %s", i, err)
+		}
+		n, err := checkLinks(buf.Bytes())
+		if err != nil {
+			t.Errorf("Part %d: %s", i, err)
+		}
+		links += n
+	}
+	if links != 1 {
+		t.Errorf("%d links in parts", links)
+	}
+}
@@ -0,0 +1,211 @@
+package tarindex
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+)
+
+const (
+	dirChunkSize   = 100 // Number of directory entries read at once.
+	dirChunkBuffer = 4   // Number of chunks a worker reads ahead.
+)
+
+// dirSortRun is the number of names of a directory that are sorted in memory. Wider directories are sorted in runs
+// of this size that are written to temporary files and merged, see nameMerger.
+var dirSortRun = 1 << 17
+
+type dirChunk struct {
+	entries []os.FileInfo
+	skipped []*skippedName
+	err     error
+}
+
+// skippedName is a directory entry that could not be read.
+type skippedName struct {
+	name string
+	err  error
+}
+
+// dirReader reads the entries of a directory in chunks, either directly when calling next, or ahead of time by
+// a worker goroutine.
+type dirReader struct {
+	name    string
+	sorted  bool
+	names   []string    // Sorted names not yet returned.
+	merger  *nameMerger // Sorted names not yet returned, of directories wider than dirSortRun.
+	listed  bool        // True if names have been read.
+	d       *os.File
+	err     error
+	opened  chan error     // Set if read by a worker. Receives the result of opening the directory.
+	chunks  chan *dirChunk // Set if read by a worker.
+	done    chan struct{}
+	release func()
+}
+
+// openDir returns a dirReader for dir. If a worker is available, reading starts immediately.
+func (list *lister) openDir(dir string) *dirReader {
+	r := &dirReader{name: dir, sorted: !list.options.unsorted}
+	select {
+	case list.workers <- struct{}{}:
+	default:
+		return r
+	}
+	r.opened = make(chan error, 1)
+	r.chunks = make(chan *dirChunk, dirChunkBuffer)
+	r.done = make(chan struct{})
+	r.release = func() { <-list.workers }
+	go func() {
+		defer close(r.chunks)
+		var err error
+		r.d, err = os.Open(r.name)
+		r.opened <- err
+		if err != nil {
+			return
+		}
+		for {
+			entries, skipped, err := r.read()
+			select {
+			case r.chunks <- &dirChunk{entries: entries, skipped: skipped, err: err}:
+			case <-r.done:
+				return
+			}
+			if len(entries) == 0 && len(skipped) == 0 {
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// open opens the directory, or waits for the worker to open it.
+func (r *dirReader) open() error {
+	if r.opened != nil {
+		r.err = <-r.opened
+		r.opened = nil
+	} else if r.chunks == nil && r.d == nil && r.err == nil {
+		r.d, r.err = os.Open(r.name)
+	}
+	return r.err
+}
+
+// list reads and sorts the names of the open directory. Up to dirSortRun names are kept in memory, more are sorted
+// in runs on disk.
+func (r *dirReader) list() error {
+	var run []string
+	merger := new(nameMerger)
+	for {
+		names, err := r.d.Readdirnames(dirSortRun)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			merger.close()
+			return err
+		}
+		sort.Strings(names)
+		if run != nil {
+			if err := merger.addRun(run); err != nil {
+				merger.close()
+				return err
+			}
+		}
+		run = names
+	}
+	if len(merger.runs) == 0 {
+		r.names = run
+		return nil
+	}
+	if run != nil {
+		if err := merger.addRun(run); err != nil {
+			merger.close()
+			return err
+		}
+	}
+	r.merger = merger
+	return merger.init()
+}
+
+// nextName returns the next sorted name, and false after the last name.
+func (r *dirReader) nextName() (string, bool, error) {
+	if r.merger != nil {
+		return r.merger.next()
+	}
+	if len(r.names) == 0 {
+		return "", false, nil
+	}
+	name := r.names[0]
+	r.names = r.names[1:]
+	return name, true, nil
+}
+
+// read returns the next chunk of entries from the open directory, and the entries that could not be read. Sorted
+// directories are read completely first.
+func (r *dirReader) read() ([]os.FileInfo, []*skippedName, error) {
+	if !r.sorted {
+		entries, err := r.d.Readdir(dirChunkSize)
+		return entries, nil, err
+	}
+	if !r.listed {
+		r.listed = true
+		if err := r.list(); err != nil {
+			return nil, nil, err
+		}
+	}
+	var skipped []*skippedName
+	entries := make([]os.FileInfo, 0, dirChunkSize)
+	for len(entries) < dirChunkSize {
+		base, ok, err := r.nextName()
+		if err != nil {
+			return entries, skipped, err
+		} else if !ok {
+			break
+		}
+		name := path.Join(r.name, base)
+		fi, err := os.Lstat(name)
+		if os.IsNotExist(err) {
+			// Removed since reading the directory.
+			continue
+		} else if err != nil {
+			skipped = append(skipped, &skippedName{name: name, err: err})
+			continue
+		}
+		entries = append(entries, fi)
+	}
+	if len(entries) == 0 && len(skipped) == 0 {
+		return entries, nil, io.EOF
+	}
+	return entries, skipped, nil
+}
+
+// next returns the next chunk of entries, and the entries that could not be read. It returns io.EOF after the last
+// entry.
+func (r *dirReader) next() ([]os.FileInfo, []*skippedName, error) {
+	if r.chunks == nil {
+		if err := r.open(); err != nil {
+			return nil, nil, err
+		}
+		return r.read()
+	}
+	chunk, ok := <-r.chunks
+	if !ok {
+		return nil, nil, io.EOF
+	}
+	return chunk.entries, chunk.skipped, chunk.err
+}
+
+// close stops reading and releases the worker.
+func (r *dirReader) close() {
+	if r.chunks != nil {
+		close(r.done)
+		for range r.chunks {
+		}
+		r.release()
+	}
+	if r.d != nil {
+		_ = r.d.Close()
+	}
+	if r.merger != nil {
+		r.merger.close()
+	}
+}
@@ -0,0 +1,117 @@
+package tarindex
+
+import (
+	"bufio"
+	"container/heap"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// nameRun is a file of sorted names, each terminated by a NUL byte, which cannot be part of a name.
+type nameRun struct {
+	f    *os.File
+	r    *bufio.Reader
+	head string // Next name of the run.
+}
+
+// advance reads the next name of the run into head. It returns false at the end of the run.
+func (run *nameRun) advance() (bool, error) {
+	name, err := run.r.ReadString(0)
+	if err == io.EOF && name == "" {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	run.head = name[:len(name)-1]
+	return true, nil
+}
+
+// nameMerger merges sorted runs of names. It is a heap of the runs that have names left, ordered by their next name.
+type nameMerger struct {
+	files []*os.File // All runs, to be removed by close.
+	runs  []*nameRun
+}
+
+func (m *nameMerger) Len() int           { return len(m.runs) }
+func (m *nameMerger) Less(i, j int) bool { return m.runs[i].head < m.runs[j].head }
+func (m *nameMerger) Swap(i, j int)      { m.runs[i], m.runs[j] = m.runs[j], m.runs[i] }
+func (m *nameMerger) Push(x interface{}) { m.runs = append(m.runs, x.(*nameRun)) }
+func (m *nameMerger) Pop() interface{} {
+	run := m.runs[len(m.runs)-1]
+	m.runs = m.runs[:len(m.runs)-1]
+	return run
+}
+
+// addRun writes the sorted names to a temporary file.
+func (m *nameMerger) addRun(names []string) error {
+	f, err := ioutil.TempFile("", "tarindex.names.")
+	if err != nil {
+		return err
+	}
+	m.files = append(m.files, f)
+	w := bufio.NewWriter(f)
+	for _, name := range names {
+		if _, err := w.WriteString(name); err != nil {
+			return err
+		}
+		if err := w.WriteByte(0); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	m.runs = append(m.runs, &nameRun{f: f})
+	return nil
+}
+
+// init prepares reading the runs, after all have been added.
+func (m *nameMerger) init() error {
+	runs := m.runs
+	m.runs = m.runs[:0]
+	for _, run := range runs {
+		if _, err := run.f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		run.r = bufio.NewReader(run.f)
+		if ok, err := run.advance(); err != nil {
+			return err
+		} else if ok {
+			m.runs = append(m.runs, run)
+		}
+	}
+	heap.Init(m)
+	return nil
+}
+
+// next returns the smallest name of all runs, and false after the last name. After an error no more names are
+// returned.
+func (m *nameMerger) next() (string, bool, error) {
+	if len(m.runs) == 0 {
+		return "", false, nil
+	}
+	run := m.runs[0]
+	name := run.head
+	ok, err := run.advance()
+	if err != nil {
+		m.runs = nil
+		return "", false, err
+	}
+	if ok {
+		heap.Fix(m, 0)
+	} else {
+		heap.Pop(m)
+	}
+	return name, true, nil
+}
+
+// close closes and removes the files of all runs.
+func (m *nameMerger) close() {
+	for _, f := range m.files {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+	m.files = nil
+	m.runs = nil
+}
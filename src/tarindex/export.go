@@ -0,0 +1,229 @@
+package tarindex
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrExportFormat is returned for unknown export formats.
+var ErrExportFormat = errors.New("unknown export format")
+
+// ExportFormat is a format in which an index can be exported.
+type ExportFormat string
+
+const (
+	ExportJSON ExportFormat = "jsonl" // One JSON object per entry and line.
+	ExportCSV  ExportFormat = "csv"   // A header line followed by one line per entry.
+	ExportSQL  ExportFormat = "sql"   // SQL statements that create and fill the tables "entries" and "index_meta".
+	// ExportSQLite is a SQLite database file with the tables of ExportSQL.
+	ExportSQLite ExportFormat = "sqlite"
+)
+
+// exportSchema creates the tables of ExportSQL. It is understood by SQLite.
+const exportSchema = `CREATE TABLE entries (
+  path TEXT NOT NULL,
+  dir TEXT NOT NULL,
+  type TEXT NOT NULL,
+  size INTEGER NOT NULL,
+  first_byte INTEGER NOT NULL,
+  last_byte INTEGER NOT NULL,
+  meta TEXT
+);
+CREATE TABLE index_meta (
+  key TEXT PRIMARY KEY,
+  value TEXT NOT NULL
+);
+`
+
+var exportColumns = []string{"path", "dir", "type", "size", "first_byte", "last_byte", "meta"}
+
+// ExportEntry is an entry of an exported index.
+type ExportEntry struct {
+	Path      string   `json:"path"`           // Path in the tar stream, cleaned.
+	Dir       string   `json:"dir"`            // Directory that contains Path.
+	Type      string   `json:"type"`           // Name of the entry type.
+	Size      int64    `json:"size"`           // Size of the content of a file, see contentSize.
+	FirstByte int64    `json:"first_byte"`     // First byte of the entry in the tar stream.
+	LastByte  int64    `json:"last_byte"`      // Byte after the entry in the tar stream.
+	Meta      Metadata `json:"meta,omitempty"` // Metadata recorded in the index.
+}
+
+// contentSize returns the size of the content of e, as read from an index, without accessing the filesystem. Without
+// size metadata (createindex -stat) the size of a file is rounded up to the tar block size.
+func contentSize(e *ListEntry) int64 {
+	if e.Type != EntryTypeFile {
+		return 0
+	}
+	if size, _, ok := e.Meta.stat(); ok {
+		return size
+	}
+	if size, _, ok := e.Meta.sparse(); ok {
+		return size
+	}
+	return e.Size - tarHeaderSize - e.Meta.paxHeaderSize()
+}
+
+func newExportEntry(e *ListEntry, fixPath func(string) string) *ExportEntry {
+	name := path.Clean(fixPath(e.Name))
+	return &ExportEntry{
+		Path:      name,
+		Dir:       path.Dir(name),
+		Type:      e.Type.String(),
+		Size:      contentSize(e),
+		FirstByte: e.FirstByte,
+		LastByte:  e.LastByte,
+		Meta:      e.Meta,
+	}
+}
+
+// metaJSON returns the metadata as a JSON object, or an empty string if there is none.
+func (e *ExportEntry) metaJSON() (string, error) {
+	if len(e.Meta) == 0 {
+		return "", nil
+	}
+	d, err := json.Marshal(e.Meta)
+	return string(d), err
+}
+
+func (e *ExportEntry) record() ([]string, error) {
+	meta, err := e.metaJSON()
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		e.Path,
+		e.Dir,
+		e.Type,
+		strconv.FormatInt(e.Size, 10),
+		strconv.FormatInt(e.FirstByte, 10),
+		strconv.FormatInt(e.LastByte, 10),
+		meta,
+	}, nil
+}
+
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func (e *ExportEntry) sqlInsert() (string, error) {
+	meta, err := e.metaJSON()
+	if err != nil {
+		return "", err
+	}
+	metaValue := "NULL"
+	if meta != "" {
+		metaValue = sqlString(meta)
+	}
+	return fmt.Sprintf("INSERT INTO entries VALUES (%s, %s, %s, %d, %d, %d, %s);\n",
+		sqlString(e.Path), sqlString(e.Dir), sqlString(e.Type), e.Size, e.FirstByte, e.LastByte, metaValue), nil
+}
+
+// indexMetaRows returns the rows of table index_meta, the root directory, the tar size and the metadata of the index,
+// sorted by key.
+func indexMetaRows(root string, size int64, meta Metadata) (keys []string, values Metadata) {
+	values = Metadata{"root": root, "size": strconv.FormatInt(size, 10)}
+	for key, value := range meta {
+		values["meta."+key] = value
+	}
+	keys = make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, values
+}
+
+// writeSQLIndexMeta writes the root directory, the tar size and the metadata of the index to table index_meta.
+func writeSQLIndexMeta(w io.Writer, root string, size int64, meta Metadata) error {
+	keys, values := indexMetaRows(root, size, meta)
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(w, "INSERT INTO index_meta VALUES (%s, %s);\n", sqlString(key), sqlString(values[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportIndex writes the entries of the index in r to w in format. Paths are written as contained in the tar stream.
+// The filesystem is not accessed. ExportSQL and ExportSQLite also export the root directory, size and metadata of the
+// index.
+func ExportIndex(r io.ReadSeeker, w io.Writer, format ExportFormat) error {
+	size, root, err := IndexHeader(r)
+	if err != nil && err != ErrMissingHeader {
+		return err
+	}
+	meta := make(Metadata)
+	if err == nil {
+		if meta, err = IndexMetadata(r); err != nil {
+			return err
+		}
+	}
+	fixPath := IndexFixPath(root, meta)
+	if format == ExportSQLite {
+		return exportSQLite(r, w, root, size, meta, fixPath)
+	}
+	bw := bufio.NewWriter(w)
+	var entryFunc func(*ExportEntry) error
+	var cw *csv.Writer
+	switch format {
+	case ExportJSON:
+		enc := json.NewEncoder(bw)
+		entryFunc = func(e *ExportEntry) error {
+			return enc.Encode(e)
+		}
+	case ExportCSV:
+		cw = csv.NewWriter(bw)
+		if err := cw.Write(exportColumns); err != nil {
+			return err
+		}
+		entryFunc = func(e *ExportEntry) error {
+			record, err := e.record()
+			if err != nil {
+				return err
+			}
+			return cw.Write(record)
+		}
+	case ExportSQL:
+		if _, err := bw.WriteString("BEGIN TRANSACTION;\n" + exportSchema); err != nil {
+			return err
+		}
+		if err := writeSQLIndexMeta(bw, root, size, meta); err != nil {
+			return err
+		}
+		entryFunc = func(e *ExportEntry) error {
+			stmt, err := e.sqlInsert()
+			if err != nil {
+				return err
+			}
+			_, err = bw.WriteString(stmt)
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrExportFormat, format)
+	}
+	if err := ReadIndex(r, func(e *ListEntry) error {
+		return entryFunc(newExportEntry(e, fixPath))
+	}); err != nil {
+		return err
+	}
+	switch format {
+	case ExportCSV:
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	case ExportSQL:
+		if _, err := bw.WriteString("COMMIT;\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,113 @@
+package tarindex
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestExportIndex(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(path.Join(dir, "sub"), 0755); err != nil {
+		t.Fatalf("Mkdir: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "sub", "it's"), []byte("content"), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	f, err := ioutil.TempFile(t.TempDir(), "index.")
+	if err != nil {
+		t.Fatalf("TempFile: %s", err)
+	}
+	defer func() { _ = f.Close() }()
+	if err := WriteIndex(dir, f, OptStat); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := ExportIndex(f, buf, ExportJSON); err != nil {
+		t.Fatalf("ExportIndex JSON: %s", err)
+	}
+	var entries []*ExportEntry
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		e := new(ExportEntry)
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			t.Fatalf("Unmarshal: %s", err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("%d entries exported", len(entries))
+	}
+	if e := entries[2]; e.Path != "sub/it's" || e.Dir != "sub" || e.Type != "file" || e.Size != 7 ||
+		e.FirstByte != 1024 || e.LastByte != 2048 || e.Meta[MetaSize] != "7" {
+		t.Errorf("Wrong file entry: %+v", e)
+	}
+
+	buf.Reset()
+	if err := ExportIndex(f, buf, ExportCSV); err != nil {
+		t.Fatalf("ExportIndex CSV: %s", err)
+	}
+	records, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	if len(records) != 4 || strings.Join(records[0], ",") != strings.Join(exportColumns, ",") {
+		t.Fatalf("Wrong CSV: %v", records)
+	}
+	if r := records[3]; r[0] != "sub/it's" || r[3] != "7" || !strings.Contains(r[6], `"size":"7"`) {
+		t.Errorf("Wrong CSV record: %v", r)
+	}
+
+	buf.Reset()
+	if err := ExportIndex(f, buf, ExportSQL); err != nil {
+		t.Fatalf("ExportIndex SQL: %s", err)
+	}
+	statements := buf.String()
+	if !strings.HasPrefix(statements, "BEGIN TRANSACTION;\n") || !strings.HasSuffix(statements, "COMMIT;\n") {
+		t.Errorf("SQL not in a transaction")
+	}
+	if !strings.Contains(statements, "INSERT INTO entries VALUES ('sub/it''s', 'sub', 'file', 7, 1024, 2048, ") {
+		t.Errorf("File not inserted:\n%s", statements)
+	}
+	if !strings.Contains(statements, "INSERT INTO index_meta VALUES ('root', "+sqlString(dir)+");") {
+		t.Errorf("Root not inserted:\n%s", statements)
+	}
+
+	buf.Reset()
+	if err := ExportIndex(f, buf, ExportSQLite); err != nil {
+		t.Fatalf("ExportIndex SQLite: %s", err)
+	}
+	dbFile := path.Join(t.TempDir(), "index.sqlite")
+	if err := ioutil.WriteFile(dbFile, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	db, err := sql.Open("sqlite", dbFile)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer func() { _ = db.Close() }()
+	var size, firstByte int64
+	var meta string
+	row := db.QueryRow("SELECT size, first_byte, meta FROM entries WHERE path = ?", "sub/it's")
+	if err := row.Scan(&size, &firstByte, &meta); err != nil || size != 7 || firstByte != 1024 ||
+		!strings.Contains(meta, `"size":"7"`) {
+		t.Errorf("Wrong SQLite entry: %d %d %s, %v", size, firstByte, meta, err)
+	}
+	var root string
+	if err := db.QueryRow("SELECT value FROM index_meta WHERE key = 'root'").Scan(&root); err != nil || root != dir {
+		t.Errorf("Wrong SQLite root: %s, %v", root, err)
+	}
+
+	if err := ExportIndex(f, buf, "xml"); !errors.Is(err, ErrExportFormat) {
+		t.Errorf("Unknown format: %v", err)
+	}
+}
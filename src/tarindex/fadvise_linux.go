@@ -0,0 +1,49 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package tarindex
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	fadvSequential = 0x2 // POSIX_FADV_SEQUENTIAL
+	fadvWillNeed   = 0x3 // POSIX_FADV_WILLNEED
+	fadvDontNeed   = 0x4 // POSIX_FADV_DONTNEED
+)
+
+// fadvise announces the intended access pattern for length bytes of f starting at offset. Length 0 means until the
+// end of the file.
+func fadvise(f *os.File, offset, length int64, advice int) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall6(syscall.SYS_FADVISE64, fd, uintptr(offset), uintptr(length), uintptr(advice), 0, 0)
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// openDirect opens name for reading with O_DIRECT. direct is false if the filesystem does not support O_DIRECT and
+// the file was opened normally.
+func openDirect(name string) (f *os.File, direct bool, err error) {
+	f, err = os.OpenFile(name, os.O_RDONLY|syscall.O_DIRECT, 0)
+	if err == nil {
+		return f, true, nil
+	}
+	if pe, ok := err.(*os.PathError); ok && pe.Err == syscall.EINVAL {
+		f, err = os.Open(name)
+	}
+	return f, false, err
+}
@@ -0,0 +1,25 @@
+//go:build !linux || !(amd64 || arm64)
+// +build !linux !amd64,!arm64
+
+package tarindex
+
+import (
+	"os"
+)
+
+const (
+	fadvSequential = iota
+	fadvWillNeed
+	fadvDontNeed
+)
+
+// fadvise is not supported on this platform.
+func fadvise(f *os.File, offset, length int64, advice int) error {
+	return nil
+}
+
+// openDirect is not supported on this platform, name is opened normally.
+func openDirect(name string) (f *os.File, direct bool, err error) {
+	f, err = os.Open(name)
+	return f, false, err
+}
@@ -15,3 +15,24 @@ func TestFixPath(t *testing.T) {
 	}
 
 }
+
+func TestIndexFixPath(t *testing.T) {
+	fixPath := IndexFixPath("/data/db", rootsMetadata([]Root{
+		{Dir: "/data/db", Prefix: "./db"},
+		{Dir: "/data/db2", Prefix: "./"},
+		{Dir: "/etc/app", Prefix: "./config"},
+		{Dir: "/etc/app/sub", Prefix: "./other"},
+	}))
+	for orig, expected := range map[string]string{
+		"/data/db":         "./db/",
+		"/data/db/x":       "./db/x",
+		"/data/db2/x":      "./x",
+		"/etc/app/a/b":     "./config/a/b",
+		"/etc/app/sub/c":   "./other/c",
+		"/etc/application": "/etc/application",
+	} {
+		if n := fixPath(orig); n != expected {
+			t.Errorf("Failed: %s: %s != %s", orig, n, expected)
+		}
+	}
+}
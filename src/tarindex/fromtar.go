@@ -0,0 +1,310 @@
+package tarindex
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// GNU extensions that archive/tar does not define.
+const (
+	tarTypeGNUDirectory = 'D' // Directory with a list of its content.
+	tarTypeVolumeLabel  = 'V'
+)
+
+// countingReader tracks the position in the tar file that is being indexed.
+type countingReader struct {
+	f   *os.File
+	pos int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+// Seek allows archive/tar to skip file content without reading it.
+func (r *countingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.f.Seek(offset, whence)
+	if err == nil {
+		r.pos = pos
+	}
+	return pos, err
+}
+
+// Fields of a raw tar header and of the GNU sparse extension blocks that follow old GNU sparse headers.
+const (
+	tarSizeField          = 124
+	tarSizeEnd            = 136
+	tarTypeflagField      = 156
+	tarGNUIsExtended      = 482
+	tarGNUExtIsExtended   = 504
+	tarBase256Flag        = 0x80
+	tarMaxBase256Size     = 8 // Bytes of a base-256 number that fit into an int64.
+	tarNumberPaddingBytes = " \x00"
+)
+
+// ErrTarHeader is returned for raw tar headers that cannot be parsed.
+var ErrTarHeader = errors.New("invalid tar header")
+
+// parseTarNumber parses a numeric field of a tar header, in octal or in the base-256 encoding of GNU tar.
+func parseTarNumber(field []byte) (int64, error) {
+	if len(field) > 0 && field[0]&tarBase256Flag != 0 {
+		digits := append([]byte{field[0] &^ tarBase256Flag}, field[1:]...)
+		var n int64
+		for i, b := range digits {
+			if b != 0 && len(digits)-i > tarMaxBase256Size {
+				return 0, ErrTarHeader
+			}
+			n = n<<8 | int64(b)
+		}
+		if n < 0 {
+			return 0, ErrTarHeader
+		}
+		return n, nil
+	}
+	s := strings.Trim(string(field), tarNumberPaddingBytes)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(s, 8, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrTarHeader, err)
+	}
+	return n, nil
+}
+
+// sparseEntryEnd returns the end, including padding, of the sparse file hdr whose headers start at offset in f.
+// archive/tar reports the logical size of sparse files, so the size of their content in f is taken from the size
+// field of the raw header, or its PAX record "size". It counts the data regions and, in the PAX 1.0 format, the
+// sparse map that precedes them.
+func sparseEntryEnd(f io.ReaderAt, offset int64, hdr *tar.Header) (int64, error) {
+	block := make([]byte, tarBlockSize)
+	pos := offset
+	for {
+		if _, err := f.ReadAt(block, pos); err != nil {
+			return 0, err
+		}
+		size, err := parseTarNumber(block[tarSizeField:tarSizeEnd])
+		if err != nil {
+			return 0, err
+		}
+		pos += tarBlockSize
+		switch block[tarTypeflagField] {
+		case tar.TypeXHeader, tar.TypeXGlobalHeader, tar.TypeGNULongName, tar.TypeGNULongLink, tarTypeVolumeLabel:
+			pos += paddedTarBlockSize(size)
+			continue
+		case tar.TypeGNUSparse:
+			for extended := block[tarGNUIsExtended] != 0; extended; extended = block[tarGNUExtIsExtended] != 0 {
+				if _, err := f.ReadAt(block, pos); err != nil {
+					return 0, err
+				}
+				pos += tarBlockSize
+			}
+		}
+		if value, ok := hdr.PAXRecords["size"]; ok {
+			if size, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return 0, fmt.Errorf("%w: %s", ErrTarHeader, err)
+			}
+		}
+		return pos + paddedTarBlockSize(size), nil
+	}
+}
+
+// tarEntryType returns the entry type of a tar header. Global extended headers and volume labels are not entries,
+// they are sent as part of the following entry.
+func tarEntryType(hdr *tar.Header) (entryType EntryType, ok bool, err error) {
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA, tar.TypeCont, tar.TypeGNUSparse:
+		return EntryTypeFile, true, nil
+	case tar.TypeDir, tarTypeGNUDirectory:
+		return EntryTypeDirectory, true, nil
+	case tar.TypeSymlink:
+		return EntryTypeLink, true, nil
+	case tar.TypeLink:
+		return EntryTypeHardlink, true, nil
+	case tar.TypeFifo:
+		return EntryTypeFifo, true, nil
+	case tar.TypeChar:
+		return EntryTypeCharDevice, true, nil
+	case tar.TypeBlock:
+		return EntryTypeBlockDevice, true, nil
+	case tar.TypeXGlobalHeader, tarTypeVolumeLabel:
+		return 0, false, nil
+	default:
+		return 0, false, fmt.Errorf("%w: %s: type %q", ErrUnsupported, hdr.Name, hdr.Typeflag)
+	}
+}
+
+// isSparseHeader returns true if the content of hdr is stored in one of the GNU sparse formats, so that its size in
+// the tar file is not hdr.Size.
+func isSparseHeader(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// tarEntryMeta returns the metadata of an entry of a tar file.
+func tarEntryMeta(hdr *tar.Header, entryType EntryType) Metadata {
+	m := Metadata{
+		MetaSize:  "0",
+		MetaMTime: strconv.FormatInt(hdr.ModTime.Unix(), 10),
+	}
+	switch entryType {
+	case EntryTypeFile:
+		m[MetaSize] = strconv.FormatInt(hdr.Size, 10)
+	case EntryTypeHardlink:
+		m[MetaLinkPath] = hdr.Linkname
+	}
+	return m
+}
+
+// WriteTarIndex writes an index of the existing tar file tarFile to w. w should be an io.WriteSeeker if possible.
+// Every entry of the index refers to the bytes of its headers and content in tarFile, which are copied verbatim when
+// the tar stream is produced, so that all entries of tarFile and their byte offsets are preserved. The path, size and
+// modification time of tarFile are recorded in the metadata of the index. Size and modification time of entries are
+// recorded like with OptStat, their digests if OptDigest is given. Names longer than 256 bytes are truncated in the
+// index, they cannot be used as reference files.
+func WriteTarIndex(tarFile string, w io.Writer, options ...Option) error {
+	listOptions := newListOptions(options)
+	name, err := filepath.Abs(tarFile)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	_, fileHdr := (&ListEntry{Type: EntryTypeHeader}).BinaryEntry(0)
+	if _, err := w.Write(fileHdr[:]); err != nil {
+		return err
+	}
+	headerMeta := Metadata{
+		MetaTarFile:  name,
+		MetaTarSize:  strconv.FormatInt(fi.Size(), 10),
+		MetaTarMTime: strconv.FormatInt(fi.ModTime().Unix(), 10),
+	}
+	for _, bin := range headerMeta.BinaryEntries(EntryTypeHeaderMeta) {
+		if _, err := w.Write(bin[:]); err != nil {
+			return err
+		}
+	}
+	r := &countingReader{f: f}
+	tr := tar.NewReader(r)
+	var offset int64 // Start of the next entry, including its extended headers.
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		entryType, ok, err := tarEntryType(hdr)
+		if err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+		meta := tarEntryMeta(hdr, entryType)
+		end := r.pos // Header only entries.
+		switch {
+		case entryType == EntryTypeFile && listOptions.digest:
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				return err
+			}
+			meta[MetaDigest] = hex.EncodeToString(h.Sum(nil))
+			end = paddedTarBlockSize(r.pos)
+		case entryType == EntryTypeFile && isSparseHeader(hdr):
+			if end, err = sparseEntryEnd(f, offset, hdr); err != nil {
+				return err
+			}
+		case entryType == EntryTypeFile || hdr.Typeflag == tarTypeGNUDirectory:
+			end = r.pos + paddedTarBlockSize(hdr.Size)
+		}
+		for _, bin := range meta.BinaryEntries(EntryTypeMeta) {
+			if _, err := w.Write(bin[:]); err != nil {
+				return err
+			}
+		}
+		bin := new(BinaryEntry)
+		writeSize(bin, end)
+		bin[binaryTypePos] = byte(entryType)
+		copy(bin[binaryNamePos:binaryNameEnd], hdr.Name)
+		if _, err := w.Write(bin[:]); err != nil {
+			return err
+		}
+		offset = end
+	}
+	if w2, ok := w.(io.WriteSeeker); ok {
+		if _, err := w2.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, fileHdr = (&ListEntry{Type: EntryTypeHeader}).BinaryEntry(offset + tarFooterSize)
+		if _, err := w.Write(fileHdr[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openTarRange opens the tar file that contains e, as read from an index of a tar file. Entries of up to readAhead
+// bytes are read completely.
+func (tw *TarWriter) openTarRange(e *ListEntry, readAhead int64) *entrySource {
+	src := new(entrySource)
+	size := e.LastByte - e.FirstByte
+	if tw.DirectIOSize > 0 && size >= tw.DirectIOSize {
+		src.f, src.direct, src.err = openDirect(tw.SourceTar)
+	} else {
+		src.f, src.err = os.Open(tw.SourceTar)
+	}
+	if src.err != nil {
+		return src
+	}
+	if src.fi, src.err = src.f.Stat(); src.err == nil && src.fi.Size() < e.LastByte {
+		src.err = fmt.Errorf("%w: %s too small for %s", ErrIndexFSMismatch, tw.SourceTar, e.Name)
+	}
+	if src.err == nil && !src.direct && size <= readAhead {
+		src.data = make([]byte, size)
+		_, src.err = src.f.ReadAt(src.data, e.FirstByte)
+		_ = src.f.Close()
+		src.f = nil
+	}
+	return src
+}
+
+// writeTarRange copies the bytes of e, as read from an index of a tar file, from the tar file.
+func (tw *TarWriter) writeTarRange(e *ListEntry, src *entrySource, skipbytes, maxbytes int64) (int64, error) {
+	n := minNotNegativeA(maxbytes, e.LastByte-e.FirstByte-skipbytes)
+	if n <= 0 {
+		return 0, nil
+	}
+	if src.data != nil {
+		w, err := tw.w.Write(src.data[skipbytes : skipbytes+n])
+		return int64(w), err
+	}
+	written, err := tw.copyFile(src, e.FirstByte+skipbytes, n)
+	if err == nil && written < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return written, err
+}
@@ -0,0 +1,271 @@
+package tarindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestTar writes a tar file with extended headers, a global header, a long name and a hard link.
+func writeTestTar(t *testing.T, name string) {
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	defer func() { _ = f.Close() }()
+	tw := tar.NewWriter(f)
+	mtime := time.Unix(1600000000, 0)
+	long := "dir/" + strings.Repeat("l", 300)
+	headers := []*tar.Header{
+		{Typeflag: tar.TypeXGlobalHeader, Name: "global", PAXRecords: map[string]string{"comment": "test"}},
+		{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755, ModTime: mtime},
+		{Typeflag: tar.TypeReg, Name: "dir/file", Mode: 0644, Size: 1000, ModTime: mtime,
+			PAXRecords: map[string]string{"SCHILY.xattr.user.test": "value"}},
+		{Typeflag: tar.TypeReg, Name: long, Mode: 0644, Size: 3, ModTime: mtime},
+		{Typeflag: tar.TypeLink, Name: "dir/link", Linkname: "dir/file", ModTime: mtime},
+		{Typeflag: tar.TypeSymlink, Name: "dir/symlink", Linkname: "file", ModTime: mtime},
+	}
+	for _, hdr := range headers {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("WriteHeader: %s", err)
+		}
+		if hdr.Size > 0 {
+			if _, err := tw.Write(bytes.Repeat([]byte{'x'}, int(hdr.Size))); err != nil {
+				t.Fatalf("Write: %s", err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+}
+
+func TestWriteTarIndex(t *testing.T) {
+	dir := t.TempDir()
+	tarName := path.Join(dir, "source.tar")
+	writeTestTar(t, tarName)
+	source, err := ioutil.ReadFile(tarName)
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+	idx, err := os.Create(path.Join(dir, "index"))
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	defer func() { _ = idx.Close() }()
+	if err := WriteTarIndex(tarName, idx, OptDigest); err != nil {
+		t.Fatalf("WriteTarIndex: %s", err)
+	}
+	var types []string
+	if err := ReadIndex(idx, func(e *ListEntry) error {
+		types = append(types, e.Type.String())
+		if e.Type == EntryTypeFile && e.Meta[MetaDigest] == "" {
+			t.Errorf("%s: no digest", e.Name)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("ReadIndex: %s", err)
+	}
+	if s := strings.Join(types, " "); s != "dir file file hardlink symlink" {
+		t.Errorf("Wrong entries: %s", s)
+	}
+	size, _, err := IndexHeader(idx)
+	if err != nil {
+		t.Fatalf("IndexHeader: %s", err)
+	}
+	entryBytes := size - tarFooterSize
+	if entryBytes <= 0 || entryBytes > int64(len(source)) {
+		t.Fatalf("Wrong size %d of %d", size, len(source))
+	}
+
+	buf := new(bytes.Buffer)
+	ir, err := NewIndexReader(idx, buf, nil)
+	if err != nil {
+		t.Fatalf("NewIndexReader: %s", err)
+	}
+	if n, err := ir.SeekAndWrite("", 0, 0); err != nil || n != size {
+		t.Fatalf("SeekAndWrite: %d of %d, %v", n, size, err)
+	}
+	if !bytes.Equal(buf.Bytes()[:entryBytes], source[:entryBytes]) {
+		t.Errorf("Entries not copied verbatim")
+	}
+	tr := tar.NewReader(buf)
+	var files int
+	for hdr, err := tr.Next(); err != io.EOF; hdr, err = tr.Next() {
+		if err != nil {
+			t.Fatalf("Next: %s", err)
+		}
+		if hdr.Name == "dir/file" && hdr.PAXRecords["SCHILY.xattr.user.test"] != "value" {
+			t.Errorf("Extended header lost")
+		}
+		files++
+	}
+	if files != 6 { // Including the global header.
+		t.Errorf("%d files in tar stream", files)
+	}
+
+	buf.Reset()
+	ir, err = NewIndexReader(idx, buf, nil)
+	if err != nil {
+		t.Fatalf("NewIndexReader: %s", err)
+	}
+	if _, err := ir.SeekAndWrite("./dir/link", 0, 0); err != nil {
+		t.Fatalf("SeekAndWrite: %s", err)
+	}
+	tr = tar.NewReader(buf)
+	if hdr, err := tr.Next(); err != nil || hdr.Name != "dir/link" || hdr.Linkname != "dir/file" {
+		t.Errorf("Wrong entry after seeking: %v", err)
+	}
+
+	if n, err := VerifyIndex(idx, true, nil); err != nil || n != 0 {
+		t.Errorf("VerifyIndex: %d, %v", n, err)
+	}
+	if err := os.Truncate(tarName, 1024); err != nil {
+		t.Fatalf("Truncate: %s", err)
+	}
+	if n, err := VerifyIndex(idx, false, nil); err != nil || n != 1 {
+		t.Errorf("VerifyIndex of truncated tar: %d, %v", n, err)
+	}
+	ir, err = NewIndexReader(idx, ioutil.Discard, nil)
+	if err != nil {
+		t.Fatalf("NewIndexReader: %s", err)
+	}
+	if _, err := ir.SeekAndWrite("", 0, 0); err == nil {
+		t.Errorf("Truncated tar file not detected")
+	}
+}
+
+// rawTarHeader returns a header block in the old GNU format, which archive/tar cannot write for sparse files.
+func rawTarHeader(name string, typeflag byte, size int64) []byte {
+	block := make([]byte, tarBlockSize)
+	copy(block, name)
+	copy(block[100:], "0000644\x00")
+	copy(block[tarSizeField:], fmt.Sprintf("%011o\x00", size))
+	copy(block[136:], fmt.Sprintf("%011o\x00", 1600000000))
+	block[tarTypeflagField] = typeflag
+	copy(block[257:], "ustar  \x00")
+	return block
+}
+
+// finishTarHeader sets the checksum of a header block.
+func finishTarHeader(block []byte) []byte {
+	copy(block[148:156], "        ")
+	var sum int64
+	for _, b := range block {
+		sum += int64(b)
+	}
+	copy(block[148:], fmt.Sprintf("%06o\x00 ", sum))
+	return block
+}
+
+// paxHeader returns a PAX extended header block with records, followed by the records.
+func paxHeader(records ...string) []byte {
+	var data []byte
+	for i := 0; i < len(records); i += 2 {
+		record := " " + records[i] + "=" + records[i+1] + "\n"
+		n := len(record) + 1
+		for len(fmt.Sprint(n))+len(record) != n {
+			n++
+		}
+		data = append(data, fmt.Sprint(n)+record...)
+	}
+	return append(finishTarHeader(rawTarHeader("PaxHeaders/x", tar.TypeXHeader, int64(len(data)))),
+		padTarData(data)...)
+}
+
+// padTarData pads data to full tar blocks.
+func padTarData(data []byte) []byte {
+	return append(data, make([]byte, paddedTarBlockSize(int64(len(data))))[len(data):]...)
+}
+
+// writeSparseTestTar writes a tar file with sparse files in the GNU formats PAX 0.1, PAX 1.0 and old GNU with an
+// extension block, each followed by a regular file.
+func writeSparseTestTar(t *testing.T, name string) {
+	var b []byte
+	regular := func(name string) {
+		b = append(b, finishTarHeader(rawTarHeader(name, tar.TypeReg, 3))...)
+		b = append(b, padTarData([]byte("abc"))...)
+	}
+	data := bytes.Repeat([]byte{'d'}, 700)
+
+	b = append(b, paxHeader("GNU.sparse.size", "100000", "GNU.sparse.numblocks", "2",
+		"GNU.sparse.map", "0,300,50000,400")...)
+	b = append(b, finishTarHeader(rawTarHeader("pax01", tar.TypeReg, 700))...)
+	b = append(b, padTarData(data)...)
+	regular("after01")
+
+	sparseMap := padTarData([]byte("2\n0\n300\n50000\n400\n"))
+	b = append(b, paxHeader("GNU.sparse.major", "1", "GNU.sparse.minor", "0", "GNU.sparse.name", "pax10",
+		"GNU.sparse.realsize", "100000")...)
+	b = append(b, finishTarHeader(rawTarHeader("PaxHeaders/pax10", tar.TypeReg, int64(len(sparseMap)+700)))...)
+	b = append(b, sparseMap...)
+	b = append(b, padTarData(data)...)
+	regular("after10")
+
+	hdr := rawTarHeader("gnu", tar.TypeGNUSparse, 700)
+	ext := make([]byte, tarBlockSize)
+	for i := 0; i < 5; i++ { // Four extents fit into the header, the fifth goes into the extension block.
+		field := hdr[386+i*24:]
+		if i == 4 {
+			field = ext
+		}
+		copy(field, fmt.Sprintf("%011o\x00%011o\x00", i*10000, 140))
+	}
+	hdr[tarGNUIsExtended] = 1
+	copy(hdr[483:], fmt.Sprintf("%011o\x00", 100000))
+	b = append(b, finishTarHeader(hdr)...)
+	b = append(b, ext...)
+	b = append(b, padTarData(data)...)
+	regular("aftergnu")
+
+	b = append(b, make([]byte, tarFooterSize)...)
+	if err := ioutil.WriteFile(name, b, 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+}
+
+func TestWriteTarIndexSparse(t *testing.T) {
+	dir := t.TempDir()
+	tarName := path.Join(dir, "sparse.tar")
+	writeSparseTestTar(t, tarName)
+	fi, err := os.Stat(tarName)
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	var ends [][]int64
+	for _, options := range [][]Option{nil, {OptDigest}} {
+		idx := new(bytes.Buffer)
+		if err := WriteTarIndex(tarName, idx, options...); err != nil {
+			t.Fatalf("WriteTarIndex: %s", err)
+		}
+		var names []string
+		var entryEnds []int64
+		if err := ReadIndex(bytes.NewReader(idx.Bytes()), func(e *ListEntry) error {
+			names = append(names, e.Name)
+			entryEnds = append(entryEnds, e.LastByte)
+			if !strings.HasPrefix(e.Name, "after") && e.Meta[MetaSize] != "100000" {
+				t.Errorf("%s: size %s", e.Name, e.Meta[MetaSize])
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("ReadIndex: %s", err)
+		}
+		if s := strings.Join(names, " "); s != "pax01 after01 pax10 after10 gnu aftergnu" {
+			t.Fatalf("Wrong entries: %s", s)
+		}
+		if end := entryEnds[len(entryEnds)-1]; end != fi.Size()-tarFooterSize {
+			t.Errorf("Entries end at %d of %d", end, fi.Size())
+		}
+		ends = append(ends, entryEnds)
+	}
+	if fmt.Sprint(ends[0]) != fmt.Sprint(ends[1]) {
+		t.Errorf("Entries end at %v without digests, at %v with digests", ends[0], ends[1])
+	}
+}
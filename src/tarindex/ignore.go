@@ -0,0 +1,188 @@
+package tarindex
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ErrPattern is returned for patterns that cannot be parsed.
+var ErrPattern = errors.New("invalid pattern")
+
+// DefaultIgnoreFile is the name of per-directory ignore files used by createindex.
+const DefaultIgnoreFile = ".tarservignore"
+
+// pattern is a compiled pattern with gitignore semantics.
+type pattern struct {
+	re      *regexp.Regexp
+	negate  bool // Pattern started with "!".
+	dirOnly bool // Pattern ended with "/", it only matches directories.
+}
+
+// compilePattern compiles a single line of a gitignore file. It returns nil for blank lines and comments.
+//
+// Patterns that contain a slash other than a trailing one are relative to the directory of the pattern, other
+// patterns match the name at any depth below it. "*" and "?" do not match "/", "**" matches any number of directories.
+func compilePattern(line string) (*pattern, error) {
+	line = strings.TrimRight(line, " \t\r")
+	if strings.HasSuffix(line, "\\") {
+		line += " "
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	p := new(pattern)
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil, ErrPattern
+	}
+	expr := new(strings.Builder)
+	expr.WriteString("^")
+	if strings.HasPrefix(line, "/") {
+		line = line[1:]
+	} else if !strings.Contains(line, "/") {
+		expr.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case strings.HasPrefix(line[i:], "**/") && (i == 0 || line[i-1] == '/'):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case line[i:] == "**" && (i == 0 || line[i-1] == '/'):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(line[i+1:], ']')
+			if end < 0 {
+				return nil, ErrPattern
+			}
+			class := line[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(line):
+			i++
+			expr.WriteString(regexp.QuoteMeta(line[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(line[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, ErrPattern
+	}
+	p.re = re
+	return p, nil
+}
+
+func (p *pattern) match(name string, isDir bool) bool {
+	return (isDir || !p.dirOnly) && p.re.MatchString(name)
+}
+
+// compilePatterns compiles lines. Blank lines and comments are skipped.
+func compilePatterns(lines []string) ([]*pattern, error) {
+	patterns := make([]*pattern, 0, len(lines))
+	for _, line := range lines {
+		p, err := compilePattern(line)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns, nil
+}
+
+// ReadPatternFile returns the lines of a file of patterns in gitignore syntax.
+func ReadPatternFile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	lines := make([]string, 0, 10)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// ignoreRules are the exclude patterns that apply to a directory and its subdirectories. Rules of subdirectories
+// take precedence over the rules of their parents.
+type ignoreRules struct {
+	parent   *ignoreRules
+	base     string // Directory of the patterns, relative to the root.
+	patterns []*pattern
+}
+
+// excluded returns true if name, relative to the root, is excluded. Within one set of patterns the last matching
+// pattern wins.
+func (rules *ignoreRules) excluded(name string, isDir bool) bool {
+	for ; rules != nil; rules = rules.parent {
+		rel := name
+		if rules.base != "" {
+			rel = strings.TrimPrefix(name, rules.base+"/")
+		}
+		for i := len(rules.patterns) - 1; i >= 0; i-- {
+			if p := rules.patterns[i]; p.match(rel, isDir) {
+				return !p.negate
+			}
+		}
+	}
+	return false
+}
+
+// readIgnoreFile returns the rules of dir, which is base relative to the root. It returns rules if dir does not
+// contain an ignore file, and rules with an error if the ignore file cannot be read or parsed.
+func (rules *ignoreRules) readIgnoreFile(dir, base, ignoreFile string) (*ignoreRules, error) {
+	lines, err := ReadPatternFile(path.Join(dir, ignoreFile))
+	if os.IsNotExist(err) {
+		return rules, nil
+	} else if err != nil {
+		return rules, err
+	}
+	patterns, err := compilePatterns(lines)
+	if err != nil {
+		return rules, err
+	}
+	if len(patterns) == 0 {
+		return rules, nil
+	}
+	return &ignoreRules{parent: rules, base: base, patterns: patterns}, nil
+}
+
+// included returns true if name, relative to the root, or one of its parent directories matches the include
+// patterns. The last matching pattern wins.
+func included(patterns []*pattern, name string) bool {
+	ret := false
+	for _, p := range patterns {
+		for dir, isDir := name, false; dir != "."; dir, isDir = path.Dir(dir), true {
+			if p.match(dir, isDir) {
+				ret = !p.negate
+				break
+			}
+		}
+	}
+	return ret
+}
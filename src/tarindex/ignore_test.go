@@ -0,0 +1,219 @@
+package tarindex
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompilePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		isDir   bool
+		match   bool
+	}{
+		{"*.lock", "a.lock", false, true},
+		{"*.lock", "dir/a.lock", false, true},
+		{"*.lock", "dir/a.locks", false, false},
+		{"tmp/", "tmp", true, true},
+		{"tmp/", "tmp", false, false},
+		{"tmp/", "a/tmp", true, true},
+		{"/tmp", "a/tmp", true, false},
+		{"a/*.log", "a/b.log", false, true},
+		{"a/*.log", "a/c/b.log", false, false},
+		{"a/*.log", "x/a/b.log", false, false},
+		{"**/logs", "x/y/logs", true, true},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a/**", "a/x/y", false, true},
+		{"file?", "file1", false, true},
+		{"file?", "file10", false, false},
+		{"file[0-2]", "file1", false, true},
+		{"file[!0-2]", "file1", false, false},
+		{"\\#name", "#name", false, true},
+		{"a.b", "axb", false, false},
+	}
+	for _, test := range tests {
+		p, err := compilePattern(test.pattern)
+		if err != nil {
+			t.Fatalf("compilePattern %q: %s", test.pattern, err)
+		}
+		if match := p.match(test.name, test.isDir); match != test.match {
+			t.Errorf("Pattern %q, name %q: match %t != %t", test.pattern, test.name, match, test.match)
+		}
+	}
+	for _, line := range []string{"", "   ", "# comment"} {
+		if p, err := compilePattern(line); p != nil || err != nil {
+			t.Errorf("Line %q not skipped", line)
+		}
+	}
+	if _, err := compilePattern("file[0-2"); err != ErrPattern {
+		t.Errorf("Unterminated class: %v", err)
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	tdirName, err := ioutil.TempDir(os.TempDir(), "tarignore.")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer func() { _ = os.RemoveAll(tdirName) }()
+	files := map[string]string{
+		"a.txt":                         "",
+		"a.lock":                        "",
+		"keep.lock":                     "",
+		"tmp/x.txt":                     "",
+		"sub/b.txt":                     "",
+		"sub/b.lock":                    "",
+		"sub/c.log":                     "",
+		"sub/deep/d.log":                "",
+		"sub/" + DefaultIgnoreFile:      "*.log\n!b.lock\n",
+		"sub/deep/" + DefaultIgnoreFile: "!d.log\n",
+	}
+	for name, content := range files {
+		name = path.Join(tdirName, name)
+		if err := os.MkdirAll(path.Dir(name), 0700); err != nil {
+			t.Fatalf("MkdirAll: %s", err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0600); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+	relNames := func(options ...Option) []string {
+		names := make([]string, 0)
+		entryFunc := func(e *ListEntry) error {
+			names = append(names, strings.TrimPrefix(e.Name, tdirName))
+			return nil
+		}
+		if err := ListToFunc(tdirName, entryFunc, options...); err != nil {
+			t.Fatalf("ListToFunc: %s", err)
+		}
+		return names
+	}
+	expect := []string{"", "/a.txt", "/keep.lock", "/sub", "/sub/" + DefaultIgnoreFile, "/sub/b.lock", "/sub/b.txt",
+		"/sub/deep", "/sub/deep/" + DefaultIgnoreFile, "/sub/deep/d.log"}
+	names := relNames(OptExclude("*.lock", "!keep.lock", "tmp/"), OptIgnoreFile(DefaultIgnoreFile))
+	if !reflect.DeepEqual(names, expect) {
+		t.Errorf("Exclude: %v", names)
+	}
+	expect = []string{"", "/sub", "/sub/b.txt", "/sub/deep", "/tmp", "/tmp/x.txt"}
+	if names := relNames(OptInclude("*.txt"), OptExclude("a.*")); !reflect.DeepEqual(names, expect) {
+		t.Errorf("Include: %v", names)
+	}
+	expect = []string{"", "/sub", "/sub/deep", "/sub/deep/" + DefaultIgnoreFile, "/sub/deep/d.log", "/tmp"}
+	if names := relNames(OptInclude("sub/deep/")); !reflect.DeepEqual(names, expect) {
+		t.Errorf("Include directory: %v", names)
+	}
+
+	idx := new(bytes.Buffer)
+	if err := WriteIndex(tdirName, idx, OptExclude("*.lock", "tmp/"), OptIgnoreFile(DefaultIgnoreFile)); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+	meta, err := IndexMetadata(bytes.NewReader(idx.Bytes()))
+	if err != nil {
+		t.Fatalf("IndexMetadata: %s", err)
+	}
+	if expect := (Metadata{MetaExclude: "*.lock\ntmp/", MetaIgnoreFile: DefaultIgnoreFile}); !reflect.DeepEqual(meta, expect) {
+		t.Errorf("Metadata: %v", meta)
+	}
+	ir, err := NewIndexReader(bytes.NewReader(idx.Bytes()), ioutil.Discard, nil)
+	if err != nil {
+		t.Fatalf("NewIndexReader: %s", err)
+	}
+	if !reflect.DeepEqual(ir.Metadata(), meta) {
+		t.Errorf("IndexReader metadata: %v", ir.Metadata())
+	}
+}
+
+func TestIgnoreRulesCurrentDir(t *testing.T) {
+	tdirName := t.TempDir()
+	for _, name := range []string{".git/HEAD", "sub/.git/HEAD", "sub/a.txt", "b.txt", "git/c.txt"} {
+		name = path.Join(tdirName, name)
+		if err := os.MkdirAll(path.Dir(name), 0700); err != nil {
+			t.Fatalf("MkdirAll: %s", err)
+		}
+		if err := ioutil.WriteFile(name, nil, 0600); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(tdirName, "sub", DefaultIgnoreFile), []byte("a.txt\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd: %s", err)
+	}
+	if err := os.Chdir(tdirName); err != nil {
+		t.Fatalf("Chdir: %s", err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+	names := make([]string, 0)
+	if err := ListToFunc(".", func(e *ListEntry) error {
+		names = append(names, e.Name)
+		return nil
+	}, OptExclude(".git/", "/b.txt"), OptIgnoreFile(DefaultIgnoreFile)); err != nil {
+		t.Fatalf("ListToFunc: %s", err)
+	}
+	expect := []string{".", "git", "git/c.txt", "sub", "sub/" + DefaultIgnoreFile}
+	if !reflect.DeepEqual(names, expect) {
+		t.Errorf("Listed %v", names)
+	}
+}
+
+func TestBadIgnoreFile(t *testing.T) {
+	tdirName := t.TempDir()
+	ignoreFile := path.Join(tdirName, "sub", DefaultIgnoreFile)
+	for name, content := range map[string]string{"sub/secret": "", "sub/" + DefaultIgnoreFile: "secret\nfile[0-2\n"} {
+		name = path.Join(tdirName, name)
+		if err := os.MkdirAll(path.Dir(name), 0700); err != nil {
+			t.Fatalf("MkdirAll: %s", err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0600); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+	skipped := make(map[string]error)
+	report := OptReportSkipped(func(name string, err error) {
+		skipped[name] = err
+	})
+	if err := ListToFunc(tdirName, func(*ListEntry) error { return nil }, OptIgnoreFile(DefaultIgnoreFile), report); err != nil {
+		t.Fatalf("ListToFunc: %s", err)
+	}
+	if len(skipped) != 1 || skipped[ignoreFile] != ErrPattern {
+		t.Errorf("Skipped %v", skipped)
+	}
+	err := ListToFunc(tdirName, func(*ListEntry) error { return nil }, OptIgnoreFile(DefaultIgnoreFile), OptStrict)
+	if err != ErrPattern {
+		t.Errorf("Strict: %v", err)
+	}
+}
+
+func TestMetadataRecords(t *testing.T) {
+	meta := Metadata{"a": strings.Repeat("x", 600), "b": "", "c": "line\nline"}
+	var payload []byte
+	for _, bin := range meta.BinaryEntries(EntryTypeMeta) {
+		if EntryType(bin[binaryTypePos]) != EntryTypeMeta {
+			t.Fatal("Wrong record type")
+		}
+		d, err := bin.payload()
+		if err != nil {
+			t.Fatalf("payload: %s", err)
+		}
+		payload = append(payload, d...)
+	}
+	meta2, err := parseMetadata(payload)
+	if err != nil {
+		t.Fatalf("parseMetadata: %s", err)
+	}
+	if !reflect.DeepEqual(meta, meta2) {
+		t.Errorf("Metadata differs: %v", meta2)
+	}
+	if _, err := parseMetadata([]byte("5 a=b")); err != ErrMetadata {
+		t.Errorf("Invalid payload: %v", err)
+	}
+}
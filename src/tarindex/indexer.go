@@ -19,10 +19,16 @@ func (entry *ListEntry) TarSize() int64 {
 	switch entry.Type {
 	case EntryTypeLink:
 		return tarHeaderSize
+	case EntryTypeHardlink, EntryTypeFifo, EntryTypeCharDevice, EntryTypeBlockDevice:
+		return entry.Meta.paxHeaderSize() + tarHeaderSize
 	case EntryTypeDirectory:
-		return tarHeaderSize
+		return entry.Meta.paxHeaderSize() + tarHeaderSize
 	case EntryTypeFile:
-		return tarHeaderSize + paddedTarBlockSize(entry.Size)
+		size := entry.Size
+		if _, extents, ok := entry.Meta.sparse(); ok {
+			size = extentsSize(extents)
+		}
+		return entry.Meta.paxHeaderSize() + tarHeaderSize + paddedTarBlockSize(size)
 	default:
 		return 0
 	}
@@ -15,7 +15,8 @@ var (
 
 // IndexReader parses a tar index and produces a (partial) tar stream.
 type IndexReader struct {
-	r           io.Reader
+	s           *indexScanner
+	meta        Metadata
 	totalSize   int64
 	baseDir     string
 	postFixFile *PostfixFile
@@ -26,6 +27,11 @@ type IndexReader struct {
 	skipBytes  int64      // skipBytes number of bytes to skip on seekEntry.
 
 	noMoreSeek bool // Set to true if more seeks are impossible.
+
+	changedTrailer bool // Write ChangedFileName after the postfix file.
+
+	// Prefetch is the number of entries that are opened, and read if small, concurrently ahead of writing.
+	Prefetch int
 }
 
 // PostfixFile is a file that may be generated at the end of the tar stream.
@@ -48,17 +54,65 @@ func NewIndexReader(r io.Reader, w io.Writer, postFixFile *PostfixFile) (*IndexR
 			size += PostfixFileSize(postFixFile.Content)
 		}
 	}
+	s := newIndexScanner(r)
+	meta := make(Metadata)
+	if err == nil {
+		if meta, err = s.readHeaderMeta(); err != nil {
+			return nil, err
+		}
+	}
 	ir := &IndexReader{
-		r:           r,
+		s:           s,
+		meta:        meta,
 		totalSize:   size,
 		baseDir:     dir,
 		postFixFile: postFixFile,
 		w:           NewTarWriter(w),
 	}
-	ir.w.FixPath = PathMod{BaseDir: ir.baseDir, ModDir: "./"}.FixPath
+	ir.w.FixPath = IndexFixPath(ir.baseDir, meta)
+	ir.w.SourceTar = meta[MetaTarFile]
 	return ir, nil
 }
 
+// Writer returns the TarWriter that produces the tar stream, to configure how files are read.
+func (ir *IndexReader) Writer() *TarWriter {
+	return ir.w
+}
+
+// Metadata returns the metadata of the index.
+func (ir *IndexReader) Metadata() Metadata {
+	return ir.meta
+}
+
+// KeepIndexedSize writes files whose size changed since indexing with their indexed size, truncated or padded with
+// zeros, instead of failing (see TarWriter.KeepIndexedSize). Their paths are listed in a trailer file called
+// ChangedFileName, of fixed size, that follows the postfix file. The trailer only lists the files written by this
+// IndexReader: a download that is resumed or fetched in ranges receives a trailer that covers its last range only,
+// so the content at the offsets of the trailer differs between requests. Callers should log Changed to keep a
+// complete record. Must be called before seeking.
+func (ir *IndexReader) KeepIndexedSize() {
+	if ir.changedTrailer {
+		return
+	}
+	ir.w.KeepIndexedSize = true
+	ir.changedTrailer = true
+	if ir.totalSize != 0 {
+		ir.totalSize += changedTrailerSize
+	}
+}
+
+// postfixSize returns the size of the postfix file and the trailer.
+func (ir *IndexReader) postfixSize() int64 {
+	var size int64
+	if ir.postFixFile != nil {
+		size += PostfixFileSize(ir.postFixFile.Content)
+	}
+	if ir.changedTrailer {
+		size += changedTrailerSize
+	}
+	return size
+}
+
 // Size returns the total size of the tar stream, if known, otherwise 0.
 func (ir *IndexReader) Size() int64 {
 	return ir.totalSize
@@ -69,6 +123,7 @@ func (ir *IndexReader) Size() int64 {
 // - pos bytes from the beginning of the file with name filename.
 // If pos == 0 the complete tar stream is written.
 // maxbytes limits how many bytes starting from the beginning of the archive should be written.
+// informFunc is called with the number of bytes that will be written before writing starts, 0 if unknown.
 func (ir *IndexReader) SeekAndWrite(filename string, pos, maxbytes int64, informFunc ...func(maxBytes int64)) (int64, error) {
 	if pos > 0 && filename == "" {
 		if err := ir.SeekByte(pos); err != nil {
@@ -88,11 +143,14 @@ func (ir *IndexReader) SeekAndWrite(filename string, pos, maxbytes int64, inform
 	}
 	if len(informFunc) == 1 {
 		contentLength := func() int64 {
+			if ir.totalSize == 0 {
+				return 0
+			}
 			offset := ir.seekOffset + ir.skipBytes
-			if offset > 0 {
-				return tarBlockSize + ir.totalSize - offset
+			if ir.seekEntry != nil {
+				offset = ir.seekEntry.FirstByte + ir.skipBytes
 			}
-			return ir.totalSize
+			return minNotNegativeA(maxbytes, ir.totalSize-offset)
 		}()
 		informFunc[0](contentLength)
 	}
@@ -101,7 +159,6 @@ func (ir *IndexReader) SeekAndWrite(filename string, pos, maxbytes int64, inform
 
 // SeekByte seeks through index to find the matching entry from which to produce the tar stream.
 func (ir *IndexReader) SeekByte(pos int64) error {
-	var offset int64
 	if pos == 0 {
 		return nil
 	}
@@ -114,29 +171,24 @@ func (ir *IndexReader) SeekByte(pos int64) error {
 	ir.noMoreSeek = true
 IndexLoop:
 	for {
-		buf := new(BinaryEntry)
-		if _, err := ir.r.Read(buf[:]); err != nil {
+		entry, err := ir.s.next()
+		if err != nil {
 			if err == io.EOF {
 				break IndexLoop
 			}
 			return err
 		}
-		entry := buf.ToListEntry(offset)
-		offset = entry.LastByte
 		if entry.LastByte > pos {
 			ir.skipBytes = pos - entry.FirstByte
 			ir.seekEntry = entry
-			ir.seekOffset = offset
+			ir.seekOffset = entry.LastByte
 			return nil
 		}
 	}
+	offset := ir.s.offset
 	ir.seekOffset = offset
 	ir.skipBytes = pos - offset
-	size := offset
-	if ir.postFixFile != nil {
-		size += PostfixFileSize(ir.postFixFile.Content)
-	}
-	size += tarFooterSize
+	size := offset + ir.postfixSize() + tarFooterSize
 	if pos > size {
 		return ErrSkipBoundary
 	}
@@ -149,7 +201,6 @@ func (ir *IndexReader) matchPath(name, match string) bool {
 
 // SeekFile seeks through index to find the matching entry for filename, and then seeks pos bytes from there.
 func (ir *IndexReader) SeekFile(filename string, pos int64) error {
-	var offset int64
 	var fileFound bool
 	if filename == "" {
 		if pos > 0 {
@@ -167,15 +218,13 @@ func (ir *IndexReader) SeekFile(filename string, pos int64) error {
 	ir.noMoreSeek = true
 IndexLoop:
 	for {
-		buf := new(BinaryEntry)
-		if _, err := ir.r.Read(buf[:]); err != nil {
+		entry, err := ir.s.next()
+		if err != nil {
 			if err == io.EOF {
 				break IndexLoop
 			}
 			return err
 		}
-		entry := buf.ToListEntry(offset)
-		offset = entry.LastByte
 		if !fileFound && ir.matchPath(entry.Name, filename) {
 			// File found. Only first match is considered.
 			fileFound = true
@@ -185,7 +234,7 @@ IndexLoop:
 			// Byte found.
 			ir.skipBytes = pos - entry.FirstByte
 			ir.seekEntry = entry
-			ir.seekOffset = offset
+			ir.seekOffset = entry.LastByte
 			return nil
 		}
 	}
@@ -193,13 +242,10 @@ IndexLoop:
 		return ErrMissingFile
 	}
 	// Not found, match must be in postfix file or end-of-file padding.
+	offset := ir.s.offset
 	ir.seekOffset = offset
 	ir.skipBytes = pos - offset
-	size := offset
-	if ir.postFixFile != nil {
-		size += PostfixFileSize(ir.postFixFile.Content)
-	}
-	size += tarFooterSize
+	size := offset + ir.postfixSize() + tarFooterSize
 	if pos > size {
 		return ErrSkipBoundary
 	}
@@ -214,7 +260,6 @@ func (ir *IndexReader) WriteTar(maxbytes int64) (int64, error) {
 	if maxbytes == 0 {
 		return written, nil
 	}
-	offset := ir.seekOffset
 	if ir.seekEntry != nil {
 		if n, err = ir.w.WriteEntry(ir.seekEntry, ir.skipBytes, maxbytes); err != nil {
 			return n, err
@@ -227,31 +272,36 @@ func (ir *IndexReader) WriteTar(maxbytes int64) (int64, error) {
 		}
 	}
 	if ir.skipBytes == 0 {
-	IndexLoop:
-		for {
-			buf := new(BinaryEntry)
-			if _, err := ir.r.Read(buf[:]); err != nil {
-				if err == io.EOF {
-					break IndexLoop
-				}
-				return written, err
-			}
-			entry := buf.ToListEntry(offset)
-			offset = entry.LastByte
-			if n, err = ir.w.WriteEntry(entry, 0, maxbytes); err != nil {
+		n, err = ir.writeEntries(maxbytes)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		maxbytes -= n
+		if maxbytes == 0 {
+			return written, nil
+		}
+	}
+	if ir.postFixFile != nil {
+		postfixSize := PostfixFileSize(ir.postFixFile.Content)
+		if ir.skipBytes < postfixSize {
+			if n, err = ir.w.AddPostfixFile(ir.postFixFile.Name, ir.postFixFile.Content, ir.skipBytes, maxbytes); err != nil {
 				return n + written, err
 			}
 			written += n
 			maxbytes -= n
+			ir.skipBytes = 0
 			if maxbytes == 0 {
 				return written, nil
 			}
+		} else if ir.skipBytes > 0 {
+			ir.skipBytes -= postfixSize
 		}
 	}
-	if ir.postFixFile != nil {
-		postfixSize := PostfixFileSize(ir.postFixFile.Content)
-		if ir.skipBytes < postfixSize {
-			if n, err = ir.w.AddPostfixFile(ir.postFixFile.Name, ir.postFixFile.Content, ir.skipBytes, maxbytes); err != nil {
+	if ir.changedTrailer {
+		if ir.skipBytes < changedTrailerSize {
+			content := changedContent(ir.w.Changed(), ir.w.fixPath)
+			if n, err = ir.w.AddPostfixFile(ChangedFileName, content, ir.skipBytes, maxbytes); err != nil {
 				return n + written, err
 			}
 			written += n
@@ -261,7 +311,7 @@ func (ir *IndexReader) WriteTar(maxbytes int64) (int64, error) {
 				return written, nil
 			}
 		} else if ir.skipBytes > 0 {
-			ir.skipBytes -= postfixSize
+			ir.skipBytes -= changedTrailerSize
 		}
 	}
 	if n, err = ir.w.Close(ir.skipBytes, maxbytes); err != nil {
@@ -272,3 +322,64 @@ func (ir *IndexReader) WriteTar(maxbytes int64) (int64, error) {
 	}
 	return written, nil
 }
+
+// writeEntries writes the entries that follow the current position in the index, up to maxbytes bytes (unlimited if
+// negative).
+func (ir *IndexReader) writeEntries(maxbytes int64) (int64, error) {
+	var written int64
+	next := func() (*ListEntry, *entrySource, error) {
+		entry, err := ir.s.next()
+		if err != nil {
+			return nil, nil, err
+		}
+		return entry, ir.w.openEntry(entry, 0), nil
+	}
+	if ir.Prefetch > 0 {
+		limit := int64(-1)
+		if maxbytes > 0 {
+			limit = ir.s.offset + maxbytes
+		}
+		p := newPrefetcher(ir.s, ir.w, limit, ir.Prefetch)
+		defer p.stop()
+		next = p.next
+	}
+	for {
+		entry, src, err := next()
+		if err != nil {
+			if err == io.EOF {
+				return written, nil
+			}
+			return written, err
+		}
+		n, err := ir.w.writeEntry(entry, src, 0, maxbytes)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		maxbytes -= n
+		if maxbytes == 0 {
+			return written, nil
+		}
+	}
+}
+
+// writeTrailer writes the postfix file, the trailer of changed files and the end of the archive.
+func (ir *IndexReader) writeTrailer() (int64, error) {
+	var written int64
+	if ir.postFixFile != nil {
+		n, err := ir.w.AddPostfixFile(ir.postFixFile.Name, ir.postFixFile.Content, 0, -1)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	if ir.changedTrailer {
+		n, err := ir.w.AddPostfixFile(ChangedFileName, changedContent(ir.w.Changed(), ir.w.fixPath), 0, -1)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	n, err := ir.w.Close(0, -1)
+	return written + n, err
+}
@@ -0,0 +1,108 @@
+package tarindex
+
+import (
+	"io"
+)
+
+// indexScanner reads the entries of an index together with their metadata, and calculates their offsets.
+type indexScanner struct {
+	r       io.Reader
+	offset  int64        // Last byte of the last entry.
+	pending *BinaryEntry // Record read ahead.
+}
+
+func newIndexScanner(r io.Reader) *indexScanner {
+	return &indexScanner{r: r}
+}
+
+// scanIndex returns a scanner of the entries of the index in r and the metadata of its header, which is empty if the
+// index has no header. Like IndexHeader, it reads from the start of r if r is an io.ReadSeeker.
+func scanIndex(r io.Reader) (*indexScanner, Metadata, error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+	}
+	s := newIndexScanner(r)
+	buf, err := s.readRecord()
+	if err != nil {
+		return nil, nil, err
+	}
+	if EntryType(buf[binaryTypePos]) != EntryTypeHeader {
+		// The first record is already an entry.
+		s.pending = buf
+		return s, make(Metadata), nil
+	}
+	meta, err := s.readHeaderMeta()
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, meta, nil
+}
+
+func (s *indexScanner) readRecord() (*BinaryEntry, error) {
+	if s.pending != nil {
+		buf := s.pending
+		s.pending = nil
+		return buf, nil
+	}
+	buf := new(BinaryEntry)
+	if _, err := io.ReadFull(s.r, buf[:]); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readHeaderMeta reads the metadata that follows the header of the index. It must be called directly after the header
+// has been read.
+func (s *indexScanner) readHeaderMeta() (Metadata, error) {
+	var payload []byte
+	for {
+		buf, err := s.readRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if EntryType(buf[binaryTypePos]) != EntryTypeHeaderMeta {
+			s.pending = buf
+			break
+		}
+		d, err := buf.payload()
+		if err != nil {
+			return nil, err
+		}
+		payload = append(payload, d...)
+	}
+	return parseMetadata(payload)
+}
+
+// next returns the next entry. It returns io.EOF after the last entry.
+func (s *indexScanner) next() (*ListEntry, error) {
+	var payload []byte
+	for {
+		buf, err := s.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		switch EntryType(buf[binaryTypePos]) {
+		case EntryTypeMeta:
+			d, err := buf.payload()
+			if err != nil {
+				return nil, err
+			}
+			payload = append(payload, d...)
+		case EntryTypeHeaderMeta:
+			// Only valid after the header.
+		default:
+			entry := buf.ToListEntry(s.offset)
+			s.offset = entry.LastByte
+			if payload != nil {
+				if entry.Meta, err = parseMetadata(payload); err != nil {
+					return nil, err
+				}
+			}
+			return entry, nil
+		}
+	}
+}
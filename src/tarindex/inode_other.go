@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package tarindex
+
+import (
+	"os"
+)
+
+// fileID is not supported, hard links are listed as independent files.
+func fileID(fi os.FileInfo) (inode, bool) {
+	return inode{}, false
+}
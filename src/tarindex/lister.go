@@ -3,81 +3,263 @@
 package tarindex
 
 import (
+	"io"
 	"os"
 	"path"
-	"sync/atomic"
+	"path/filepath"
+	"sync"
 )
 
 type lister struct {
-	c     chan interface{}
-	close int32
+	c        chan interface{}
+	done     chan struct{}
+	exitOnce sync.Once
+	workers  chan struct{} // Semaphore of directory reading workers.
+	options  *listOptions
+	root     string
+	include  []*pattern
+	// hardlinks maps files with more than one link to the name they were listed with first.
+	hardlinks map[inode]string
+}
+
+// inode identifies a file on a device.
+type inode struct {
+	dev, ino uint64
 }
 
 func (list *lister) closed() bool {
-	return atomic.LoadInt32(&list.close) != 0
+	select {
+	case <-list.done:
+		return true
+	default:
+		return false
+	}
 }
 
 func (list *lister) exit() {
-	atomic.StoreInt32(&list.close, 1)
+	list.exitOnce.Do(func() { close(list.done) })
 }
 
-func newLister() *lister {
+func newLister(options *listOptions) *lister {
 	return &lister{
-		c: make(chan interface{}, 10),
+		c:         make(chan interface{}, 10),
+		done:      make(chan struct{}),
+		workers:   make(chan struct{}, options.workers),
+		options:   options,
+		hardlinks: make(map[inode]string),
 	}
 }
 
-func (list *lister) closeChan() {
-	if list.c != nil {
-		close(list.c)
-		list.c = nil
+// send delivers m unless listing has been stopped.
+func (list *lister) send(m interface{}) {
+	select {
+	case list.c <- m:
+	case <-list.done:
 	}
 }
 
-func (list *lister) sendEntry(name string, entryType EntryType, size int64) {
-	if list.closed() {
-		list.closeChan()
-		return
+// sendEntry sends an entry. meta is added to the extended attributes of the entry. It returns an error, and does not
+// send the entry, if the extended attributes cannot be read.
+func (list *lister) sendEntry(name string, entryType EntryType, size int64, meta Metadata) error {
+	if list.options.xattrs && entryType != EntryTypeLink {
+		xattrs, err := readXattrs(name)
+		if err != nil {
+			return err
+		}
+		if xattrs != nil {
+			for key, value := range meta {
+				xattrs[key] = value
+			}
+			meta = xattrs
+		}
 	}
-	list.c <- &ListEntry{
+	list.send(&ListEntry{
 		Size: size,
 		Name: name,
 		Type: entryType,
+		Meta: meta,
+	})
+	return nil
+}
+
+// sendFile sends a file, or a hard link if a file with the same inode has been sent before. It returns an error, and
+// does not send the file, if its metadata cannot be read.
+func (list *lister) sendFile(name string, fi os.FileInfo) error {
+	id, hasID := fileID(fi)
+	if hasID {
+		if target, ok := list.hardlinks[id]; ok {
+			list.send(&ListEntry{
+				Name: name,
+				Type: EntryTypeHardlink,
+				Meta: Metadata{MetaLinkPath: target},
+			})
+			return nil
+		}
+	}
+	var meta Metadata
+	if list.options.stat {
+		digestName := ""
+		if list.options.digest {
+			digestName = name
+		}
+		var err error
+		if meta, err = statMetadata(fi, digestName); err != nil {
+			return err
+		}
 	}
+	if list.options.sparse {
+		extents, ok, err := fileExtents(name, fi)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if meta == nil {
+				meta = make(Metadata)
+			}
+			for key, value := range sparseMetadata(fi.Size(), extents) {
+				meta[key] = value
+			}
+		}
+	}
+	if err := list.sendEntry(name, EntryTypeFile, fi.Size(), meta); err != nil {
+		return err
+	}
+	if hasID {
+		list.hardlinks[id] = name
+	}
+	return nil
+}
+
+// sendSpecial sends FIFOs and devices if enabled. Other types are skipped and reported.
+func (list *lister) sendSpecial(name string, fi os.FileInfo) error {
+	entryType, ok := specialType(fi)
+	if ok && list.options.special {
+		return list.sendEntry(name, entryType, 0, nil)
+	}
+	if list.options.skipped == nil {
+		return nil
+	}
+	if ok {
+		list.options.skipped(name, ErrSpecialFile)
+	} else {
+		list.options.skipped(name, ErrUnsupported)
+	}
+	return nil
 }
 
-func (list *lister) addDir(dir string) error {
-	d, err := os.Open(dir)
-	if err != nil {
+// walkError reports an error reading name. In strict mode it returns the error, to stop listing.
+func (list *lister) walkError(name string, err error) error {
+	if list.options.strict {
 		return err
 	}
-	defer func() { _ = d.Close() }()
-	list.sendEntry(dir, EntryTypeDirectory, 0)
+	if list.options.skipped != nil {
+		list.options.skipped(name, err)
+	}
+	return nil
+}
+
+func closeDirs(dirs []*dirReader) {
+	for _, r := range dirs {
+		if r != nil {
+			r.close()
+		}
+	}
+}
+
+// relative returns name relative to the root directory, and an empty string for the root directory itself.
+func (list *lister) relative(name string) string {
+	rel, err := filepath.Rel(list.root, name)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// skip returns true if e in dir is excluded by rules, or not included.
+func (list *lister) skip(dir string, e os.FileInfo, rules *ignoreRules) bool {
+	name := list.relative(path.Join(dir, e.Name()))
+	if rules.excluded(name, e.IsDir()) {
+		return true
+	}
+	return list.include != nil && !e.IsDir() && !included(list.include, name)
+}
+
+func (list *lister) addDir(r *dirReader, rules *ignoreRules) error {
+	defer r.close()
+	if err := r.open(); err != nil {
+		return err
+	}
+	dir := r.name
+	if err := list.sendEntry(dir, EntryTypeDirectory, 0, nil); err != nil {
+		return err
+	}
+	if list.options.ignoreFile != "" {
+		var err error
+		if rules, err = rules.readIgnoreFile(dir, list.relative(dir), list.options.ignoreFile); err != nil {
+			if err := list.walkError(path.Join(dir, list.options.ignoreFile), err); err != nil {
+				return err
+			}
+		}
+	}
 DirLoop:
 	for {
 		if list.closed() {
 			return nil
 		}
-		entries, _ := d.Readdir(10)
-		if entries == nil || len(entries) == 0 {
+		entries, skipped, err := r.next()
+		for _, s := range skipped {
+			if err := list.walkError(s.name, s.err); err != nil {
+				return err
+			}
+		}
+		if err != nil && err != io.EOF {
+			if err := list.walkError(dir, err); err != nil {
+				return err
+			}
+		}
+		if len(entries) == 0 {
+			if len(skipped) > 0 {
+				continue DirLoop
+			}
 			break DirLoop
 		}
-	EntryLoop:
-		for _, e := range entries {
+		if rules != nil || list.include != nil {
+			kept := entries[:0]
+			for _, e := range entries {
+				if !list.skip(dir, e, rules) {
+					kept = append(kept, e)
+				}
+			}
+			entries = kept
+		}
+		subDirs := make([]*dirReader, len(entries))
+		for i, e := range entries {
+			if e.IsDir() {
+				subDirs[i] = list.openDir(path.Join(dir, e.Name()))
+			}
+		}
+		for i, e := range entries {
 			if list.closed() {
+				closeDirs(subDirs[i:])
 				return nil
 			}
 			name := path.Join(dir, e.Name())
+			var err error
 			switch {
 			case e.IsDir():
-				if err := list.addDir(name); err != nil {
-					// log.Printf("Failed to list dir '%s': %s", name, err)
-					continue EntryLoop
-				}
+				err = list.addDir(subDirs[i], rules)
 			case isLink(e):
-				list.sendEntry(name, EntryTypeLink, 0)
+				err = list.sendEntry(name, EntryTypeLink, 0, nil)
 			case isRegular(e):
-				list.sendEntry(name, EntryTypeFile, e.Size())
+				err = list.sendFile(name, e)
+			default:
+				err = list.sendSpecial(name, e)
+			}
+			if err != nil {
+				if err := list.walkError(name, err); err != nil {
+					closeDirs(subDirs[i+1:])
+					return err
+				}
 			}
 		}
 	}
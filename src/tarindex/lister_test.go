@@ -0,0 +1,215 @@
+package tarindex
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func listNames(t *testing.T, dir string, options ...Option) []string {
+	names := make([]string, 0)
+	entryFunc := func(e *ListEntry) error {
+		names = append(names, fmt.Sprintf("%d %d %s", e.Type, e.Size, e.Name))
+		return nil
+	}
+	if err := ListToFunc(dir, entryFunc, options...); err != nil {
+		t.Fatalf("ListToFunc: %s", err)
+	}
+	return names
+}
+
+func TestWorkers(t *testing.T) {
+	tdirName := writeTestTree(t)
+	defer func() { _ = os.RemoveAll(tdirName) }()
+	deep := tdirName
+	for i := 0; i < 20; i++ {
+		deep = path.Join(deep, fmt.Sprintf("deep%d", i))
+		if err := os.MkdirAll(path.Join(deep, "sibling"), 0700); err != nil {
+			t.Fatalf("MkdirAll: %s", err)
+		}
+	}
+	names := listNames(t, tdirName)
+	unsortedNames := listNames(t, tdirName, OptUnsorted)
+	for _, workers := range []int{1, 2, 8, 64} {
+		if names2 := listNames(t, tdirName, OptWorkers(workers)); !reflect.DeepEqual(names, names2) {
+			t.Errorf("Workers %d: Order differs", workers)
+		}
+		if names2 := listNames(t, tdirName, OptWorkers(workers), OptUnsorted); !reflect.DeepEqual(unsortedNames, names2) {
+			t.Errorf("Workers %d: Unsorted order differs", workers)
+		}
+	}
+	if _, err := os.Stat(path.Join(tdirName, "missing")); err == nil {
+		t.Fatal("Error creating testdata")
+	}
+	if err := ListToFunc(path.Join(tdirName, "missing"), func(*ListEntry) error { return nil }, OptWorkers(4)); err == nil {
+		t.Error("Missing directory listed")
+	}
+}
+
+func TestListerExit(t *testing.T) {
+	tdirName := writeTestTree(t)
+	defer func() { _ = os.RemoveAll(tdirName) }()
+	var n int
+	entryFunc := func(e *ListEntry) error {
+		if n++; n == 3 {
+			return os.ErrClosed
+		}
+		return nil
+	}
+	if err := ListToFunc(tdirName, entryFunc, OptWorkers(4)); err != os.ErrClosed {
+		t.Errorf("Wrong error: %v", err)
+	}
+}
+
+func TestSorted(t *testing.T) {
+	mtime := time.Unix(1600000000, 0)
+	mkTree := func(names []string) string {
+		tdirName, err := ioutil.TempDir(os.TempDir(), "tarsorted.")
+		if err != nil {
+			t.Fatalf("TempDir: %s", err)
+		}
+		for _, name := range names {
+			if err := ioutil.WriteFile(path.Join(tdirName, name), []byte(name), 0600); err != nil {
+				t.Fatalf("WriteFile: %s", err)
+			}
+			_ = os.Chtimes(path.Join(tdirName, name), mtime, mtime)
+		}
+		_ = os.Chtimes(tdirName, mtime, mtime)
+		return tdirName
+	}
+	writeTar := func(dir string) []byte {
+		idx := new(bytes.Buffer)
+		if err := WriteIndex(dir, idx, OptWorkers(2)); err != nil {
+			t.Fatalf("WriteIndex: %s", err)
+		}
+		buf := new(bytes.Buffer)
+		ir, err := NewIndexReader(idx, buf, &PostfixFile{Name: ".version", Content: []byte("v")})
+		if err != nil {
+			t.Fatalf("NewIndexReader: %s", err)
+		}
+		if _, err := ir.SeekAndWrite("", 0, 0); err != nil {
+			t.Fatalf("SeekAndWrite: %s", err)
+		}
+		return buf.Bytes()
+	}
+	names := make([]string, 0)
+	for i := 0; i < 200; i++ {
+		names = append(names, fmt.Sprintf("file%d", i*7919%200))
+	}
+	reversed := make([]string, len(names))
+	for i, name := range names {
+		reversed[len(names)-1-i] = name
+	}
+	a, b := mkTree(names), mkTree(reversed)
+	defer func() { _ = os.RemoveAll(a) }()
+	defer func() { _ = os.RemoveAll(b) }()
+	if !bytes.Equal(writeTar(a), writeTar(b)) {
+		t.Error("Tar streams differ")
+	}
+	var prev string
+	_ = ListToFunc(a, func(e *ListEntry) error {
+		if e.Name < prev {
+			t.Errorf("Not sorted: %s > %s", prev, e.Name)
+		}
+		prev = e.Name
+		return nil
+	})
+}
+
+func TestWalkErrors(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Permissions are not enforced for root")
+	}
+	tdirName := writeTestTree(t)
+	defer func() { _ = os.RemoveAll(tdirName) }()
+	locked := path.Join(tdirName, "dir2")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatalf("Chmod: %s", err)
+	}
+	defer func() { _ = os.Chmod(locked, 0700) }()
+	for _, workers := range []int{0, 4} {
+		skipped := make(map[string]error)
+		report := OptReportSkipped(func(name string, err error) {
+			skipped[name] = err
+		})
+		names := listNames(t, tdirName, report, OptWorkers(workers))
+		if len(skipped) != 1 || skipped[locked] == nil {
+			t.Errorf("Workers %d: Skipped %v", workers, skipped)
+		}
+		if len(names) != 1+4+40 {
+			t.Errorf("Workers %d: Listed %d entries", workers, len(names))
+		}
+		if err := ListToFunc(tdirName, func(*ListEntry) error { return nil }, OptStrict, OptWorkers(workers)); !os.IsPermission(err) {
+			t.Errorf("Workers %d: Strict: %v", workers, err)
+		}
+	}
+}
+
+func TestMetadataErrors(t *testing.T) {
+	tdirName := t.TempDir()
+	gone, link := path.Join(tdirName, "gone"), path.Join(tdirName, "link")
+	if err := ioutil.WriteFile(gone, []byte("content"), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	if err := os.Link(gone, link); err != nil {
+		t.Fatalf("Link: %s", err)
+	}
+	goneInfo, err := os.Lstat(gone)
+	if err != nil {
+		t.Fatalf("Lstat: %s", err)
+	}
+	linkInfo, err := os.Lstat(link)
+	if err != nil {
+		t.Fatalf("Lstat: %s", err)
+	}
+	if err := os.Remove(gone); err != nil {
+		t.Fatalf("Remove: %s", err)
+	}
+	list := newLister(newListOptions([]Option{OptDigest}))
+	if err := list.sendFile(gone, goneInfo); !os.IsNotExist(err) {
+		t.Errorf("Digest of removed file: %v", err)
+	}
+	if len(list.c) != 0 {
+		t.Fatalf("Removed file sent")
+	}
+	if err := list.sendFile(link, linkInfo); err != nil {
+		t.Fatalf("sendFile: %s", err)
+	}
+	if e := (<-list.c).(*ListEntry); e.Type != EntryTypeFile || e.Meta[MetaDigest] == "" {
+		t.Errorf("Other link of removed file: %d %v", e.Type, e.Meta)
+	}
+}
+
+func TestWideDirectory(t *testing.T) {
+	defer func(run int) { dirSortRun = run }(dirSortRun)
+	dirSortRun = dirChunkSize / 3
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+	tdirName := t.TempDir()
+	const files = 3*dirChunkSize + 7
+	for i := 0; i < files; i++ {
+		name := path.Join(tdirName, fmt.Sprintf("file%d", i*7919%files))
+		if err := ioutil.WriteFile(name, nil, 0600); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+	for _, workers := range []int{0, 4} {
+		names := listNames(t, tdirName, OptWorkers(workers))
+		if len(names) != 1+files {
+			t.Errorf("Workers %d: Listed %d entries", workers, len(names))
+		}
+		for i := 2; i < len(names); i++ {
+			if names[i] <= names[i-1] {
+				t.Errorf("Workers %d: Not sorted: %s > %s", workers, names[i-1], names[i])
+			}
+		}
+		if runs, err := ioutil.ReadDir(tmpDir); err != nil || len(runs) != 0 {
+			t.Errorf("Workers %d: %d sorted runs left: %v", workers, len(runs), err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package tarindex
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrMetadata is returned if metadata in an index cannot be parsed.
+var ErrMetadata = errors.New("invalid metadata")
+
+// Metadata contains key/value pairs that describe an entry or the index itself.
+//
+// Metadata is stored in records of type EntryTypeMeta that precede the entry they describe, or of type
+// EntryTypeHeaderMeta that follow the index header. The size field of these records contains the number of payload
+// bytes in the name field. The concatenated payload consists of records "<length> <key>=<value>\n" like in PAX
+// extended headers, where length includes the whole record.
+type Metadata map[string]string
+
+// Keys of index metadata.
+const (
+	MetaInclude    = "include"    // Include patterns, one per line.
+	MetaExclude    = "exclude"    // Exclude patterns, one per line.
+	MetaIgnoreFile = "ignorefile" // Name of per-directory ignore files.
+	MetaTarFile    = "tarfile"    // Absolute path of the tar file that contains the entries, see WriteTarIndex.
+	MetaTarSize    = "tarsize"    // Size of the tar file in bytes when it was indexed.
+	MetaTarMTime   = "tarmtime"   // Modification time of the tar file in seconds since the epoch when it was indexed.
+	MetaRoots      = "roots"      // Source directories and their prefixes, one "<directory>\t<prefix>" per line.
+)
+
+// Keys of entry metadata.
+const (
+	MetaLinkPath    = "linkpath"    // Path of the entry a hard link refers to.
+	MetaXattrPrefix = "xattr."      // Prefix of extended attributes, followed by the attribute name.
+	MetaSparseSize  = "sparse.size" // Apparent size of a sparse file.
+	MetaSparseMap   = "sparse.map"  // Data extents of a sparse file, as comma separated offset and length pairs.
+	MetaSize        = "size"        // Size of a file in bytes.
+	MetaMTime       = "mtime"       // Modification time of a file in seconds since the epoch.
+	MetaDigest      = "sha256"      // Hex encoded SHA256 of a file.
+)
+
+func formatMetaRecord(key, value string) string {
+	rest := " " + key + "=" + value + "\n"
+	size := len(rest)
+	for n := size + len(strconv.Itoa(size)); ; n++ {
+		if record := strconv.Itoa(n) + rest; len(record) == n {
+			return record
+		}
+	}
+}
+
+// encode returns the payload of m, sorted by key.
+func (m Metadata) encode() []byte {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	buf := new(bytes.Buffer)
+	for _, key := range keys {
+		buf.WriteString(formatMetaRecord(key, m[key]))
+	}
+	return buf.Bytes()
+}
+
+func parseMetadata(payload []byte) (Metadata, error) {
+	m := make(Metadata)
+	for len(payload) > 0 {
+		pos := bytes.IndexByte(payload, ' ')
+		if pos < 0 {
+			return nil, ErrMetadata
+		}
+		n, err := strconv.Atoi(string(payload[:pos]))
+		if err != nil || n <= pos+1 || n > len(payload) || payload[n-1] != '\n' {
+			return nil, ErrMetadata
+		}
+		record := string(payload[pos+1 : n-1])
+		payload = payload[n:]
+		eq := strings.IndexByte(record, '=')
+		if eq < 0 {
+			return nil, ErrMetadata
+		}
+		m[record[:eq]] = record[eq+1:]
+	}
+	return m, nil
+}
+
+// BinaryEntries returns the index records of type entryType that contain m.
+func (m Metadata) BinaryEntries(entryType EntryType) []*BinaryEntry {
+	payload := m.encode()
+	entries := make([]*BinaryEntry, 0, len(payload)/binaryNameLen+1)
+	for len(payload) > 0 {
+		bin := new(BinaryEntry)
+		n := copy(bin[binaryNamePos:binaryNameEnd], payload)
+		writeSize(bin, int64(n))
+		bin[binaryTypePos] = byte(entryType)
+		entries = append(entries, bin)
+		payload = payload[n:]
+	}
+	return entries
+}
+
+// statMetadata returns the metadata that records size and modification time of fi, and its digest if name is
+// given. It returns an error if the digest cannot be calculated.
+func statMetadata(fi os.FileInfo, name string) (Metadata, error) {
+	m := Metadata{
+		MetaSize:  strconv.FormatInt(fi.Size(), 10),
+		MetaMTime: strconv.FormatInt(fi.ModTime().Unix(), 10),
+	}
+	if name != "" {
+		digest, err := fileDigest(name)
+		if err != nil {
+			return nil, err
+		}
+		m[MetaDigest] = digest
+	}
+	return m, nil
+}
+
+// stat returns the size and modification time recorded in m, and false if they have not been recorded.
+func (m Metadata) stat() (size, mtime int64, ok bool) {
+	var err error
+	if size, err = strconv.ParseInt(m[MetaSize], 10, 64); err != nil {
+		return 0, 0, false
+	}
+	if mtime, err = strconv.ParseInt(m[MetaMTime], 10, 64); err != nil {
+		return 0, 0, false
+	}
+	return size, mtime, true
+}
+
+// payload returns the metadata bytes of a record of type EntryTypeMeta or EntryTypeHeaderMeta.
+func (bin BinaryEntry) payload() ([]byte, error) {
+	n := readSize(bin)
+	if n < 0 || n > binaryNameLen {
+		return nil, ErrMetadata
+	}
+	return bin[binaryNamePos : binaryNamePos+int(n)], nil
+}
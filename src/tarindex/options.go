@@ -0,0 +1,186 @@
+package tarindex
+
+import "strings"
+
+// Option is an option for listing directories and writing indexes.
+type Option interface {
+	applyOption(options *listOptions)
+}
+
+type listOptions struct {
+	workers    int
+	unsorted   bool
+	include    []string
+	exclude    []string
+	ignoreFile string
+	special    bool
+	xattrs     bool
+	sparse     bool
+	stat       bool
+	digest     bool
+	strict     bool
+	skipped    func(name string, err error)
+}
+
+func newListOptions(options []Option) *listOptions {
+	applied := new(listOptions)
+	for _, opt := range options {
+		opt.applyOption(applied)
+	}
+	return applied
+}
+
+type workersOption struct {
+	workers int
+}
+
+func (opt workersOption) applyOption(options *listOptions) {
+	options.workers = opt.workers
+}
+
+// OptWorkers reads up to workers directories concurrently. The order of entries is the same as without workers.
+func OptWorkers(workers int) Option {
+	return workersOption{workers: workers}
+}
+
+// OptUnsorted lists directory entries in the order returned by the filesystem, instead of sorting them bytewise.
+// Sorting makes indexes and tar streams of identical directory trees identical.
+var OptUnsorted = new(optUnsorted)
+
+type optUnsorted struct{}
+
+func (opt optUnsorted) applyOption(options *listOptions) {
+	options.unsorted = true
+}
+
+type includeOption struct {
+	patterns []string
+}
+
+func (opt includeOption) applyOption(options *listOptions) {
+	options.include = append(options.include, opt.patterns...)
+}
+
+// OptInclude only lists files and links that match one of the patterns, or that are in a directory that matches.
+// Directories are always listed unless they are excluded. Patterns use gitignore syntax, relative to the root.
+func OptInclude(patterns ...string) Option {
+	return includeOption{patterns: patterns}
+}
+
+type excludeOption struct {
+	patterns []string
+}
+
+func (opt excludeOption) applyOption(options *listOptions) {
+	options.exclude = append(options.exclude, opt.patterns...)
+}
+
+// OptExclude does not list entries that match one of the patterns. Excluded directories are not read. Patterns use
+// gitignore syntax, relative to the root, and can be negated with "!".
+func OptExclude(patterns ...string) Option {
+	return excludeOption{patterns: patterns}
+}
+
+type ignoreFileOption struct {
+	name string
+}
+
+func (opt ignoreFileOption) applyOption(options *listOptions) {
+	options.ignoreFile = opt.name
+}
+
+// OptIgnoreFile reads exclude patterns from files called name in every directory, like .gitignore files. Patterns
+// of a directory take precedence over those of its parents and over OptExclude.
+func OptIgnoreFile(name string) Option {
+	return ignoreFileOption{name: name}
+}
+
+// OptSpecialFiles lists FIFOs, character and block devices as header-only entries. Without it, they are skipped.
+var OptSpecialFiles = new(optSpecialFiles)
+
+type optSpecialFiles struct{}
+
+func (opt optSpecialFiles) applyOption(options *listOptions) {
+	options.special = true
+}
+
+// OptXattrs captures the extended attributes of directories, files, FIFOs and devices, including POSIX ACLs and file
+// capabilities. They are written as SCHILY.xattr PAX records. Only supported on Linux.
+var OptXattrs = new(optXattrs)
+
+type optXattrs struct{}
+
+func (opt optXattrs) applyOption(options *listOptions) {
+	options.xattrs = true
+}
+
+// OptSparse detects holes in files and writes files that contain holes in the GNU sparse format, so that holes are
+// not sent. Only supported on Linux.
+var OptSparse = new(optSparse)
+
+type optSparse struct{}
+
+func (opt optSparse) applyOption(options *listOptions) {
+	options.sparse = true
+}
+
+// OptStat records size and modification time of files in the index, so that VerifyIndex and WriteSync can compare
+// them exactly.
+var OptStat = new(optStat)
+
+type optStat struct{}
+
+func (opt optStat) applyOption(options *listOptions) {
+	options.stat = true
+}
+
+// OptDigest records size, modification time and SHA256 digest of files in the index. All files are read when
+// indexing.
+var OptDigest = new(optDigest)
+
+type optDigest struct{}
+
+func (opt optDigest) applyOption(options *listOptions) {
+	options.stat = true
+	options.digest = true
+}
+
+// OptStrict stops listing on the first error reading a directory or directory entry. Without it, such errors are
+// reported to OptReportSkipped and the entries are left out.
+var OptStrict = new(optStrict)
+
+type optStrict struct{}
+
+func (opt optStrict) applyOption(options *listOptions) {
+	options.strict = true
+}
+
+type reportSkippedOption struct {
+	report func(name string, err error)
+}
+
+func (opt reportSkippedOption) applyOption(options *listOptions) {
+	options.skipped = opt.report
+}
+
+// OptReportSkipped calls report for every filesystem object that is not listed because its type is not supported,
+// like sockets, or because it could not be read. err describes the reason. report is called from the listing
+// goroutine, in listing order.
+func OptReportSkipped(report func(name string, err error)) Option {
+	return reportSkippedOption{report: report}
+}
+
+// metadata returns the index metadata that records the options.
+func (options *listOptions) metadata() Metadata {
+	m := make(Metadata)
+	if len(options.include) > 0 {
+		m[MetaInclude] = strings.Join(options.include, "\n")
+	}
+	if len(options.exclude) > 0 {
+		m[MetaExclude] = strings.Join(options.exclude, "\n")
+	}
+	if options.ignoreFile != "" {
+		m[MetaIgnoreFile] = options.ignoreFile
+	}
+	return m
+}
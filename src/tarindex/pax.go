@@ -0,0 +1,73 @@
+package tarindex
+
+import (
+	"archive/tar"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// paxHeaderName is the name of extended headers. It does not depend on the name of the entry, so that the size
+	// of the extended header is known when indexing.
+	paxHeaderName = "././@PaxHeader"
+	paxXattr      = "SCHILY.xattr."
+
+	tarTypeflagPos = 156
+	tarChksumPos   = 148
+	tarChksumLen   = 8
+)
+
+// paxRecords returns the PAX records for the extended attributes and sparse map in m.
+func (m Metadata) paxRecords() []byte {
+	records := m.sparsePAXRecords()
+	for key, value := range m {
+		if strings.HasPrefix(key, MetaXattrPrefix) {
+			records = append(records, formatMetaRecord(paxXattr+strings.TrimPrefix(key, MetaXattrPrefix), value))
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	sort.Strings(records)
+	return []byte(strings.Join(records, ""))
+}
+
+// paxHeaderSize returns the size of the extended header that precedes an entry with metadata m.
+func (m Metadata) paxHeaderSize() int64 {
+	records := m.paxRecords()
+	if records == nil {
+		return 0
+	}
+	return tarHeaderSize + paddedTarBlockSize(int64(len(records)))
+}
+
+// paxHeader returns the extended header, including content and padding, that precedes an entry with metadata m,
+// or nil if none is required.
+func (m Metadata) paxHeader() ([]byte, error) {
+	records := m.paxRecords()
+	if records == nil {
+		return nil, nil
+	}
+	hdr, err := tarHeaderBytes(&tar.Header{
+		Name:     paxHeaderName,
+		Typeflag: tar.TypeReg,
+		Size:     int64(len(records)),
+		Mode:     int64(0600),
+		ModTime:  postfixModTime,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The tar package does not write extended headers itself.
+	hdr[tarTypeflagPos] = tar.TypeXHeader
+	chksum := hdr[tarChksumPos : tarChksumPos+tarChksumLen]
+	copy(chksum, "        ")
+	var sum int64
+	for _, c := range hdr[:tarHeaderSize] {
+		sum += int64(c)
+	}
+	copy(chksum, fmt.Sprintf("%06o\x00 ", sum))
+	hdr = append(hdr[:tarHeaderSize], records...)
+	return append(hdr, zeroBlock[:paddingSize(int64(len(records)))]...), nil
+}
@@ -0,0 +1,71 @@
+package tarindex
+
+import (
+	"io"
+)
+
+// prefetchReadSize is the size up to which files are read completely while prefetching.
+const prefetchReadSize = 64 * 1024
+
+// prefetchedEntry is an entry of the index together with its opened source.
+type prefetchedEntry struct {
+	entry *ListEntry
+	src   *entrySource
+	err   error // Error reading the index.
+}
+
+// prefetcher reads entries from the index and opens up to n of them concurrently ahead of the writer.
+// Entries are delivered in index order.
+type prefetcher struct {
+	queue chan chan *prefetchedEntry
+	done  chan struct{}
+}
+
+// newPrefetcher starts prefetching entries from s and opens them with w. Entries starting at or after limit are not
+// prefetched, unless limit is negative.
+func newPrefetcher(s *indexScanner, w *TarWriter, limit int64, n int) *prefetcher {
+	p := &prefetcher{
+		queue: make(chan chan *prefetchedEntry, n),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(p.queue)
+		for limit < 0 || s.offset < limit {
+			future := make(chan *prefetchedEntry, 1)
+			select {
+			case p.queue <- future:
+			case <-p.done:
+				return
+			}
+			entry, err := s.next()
+			if err != nil {
+				future <- &prefetchedEntry{err: err}
+				return
+			}
+			go func() {
+				future <- &prefetchedEntry{entry: entry, src: w.openEntry(entry, prefetchReadSize)}
+			}()
+		}
+	}()
+	return p
+}
+
+// next returns the next entry and its source. It returns io.EOF after the last entry.
+func (p *prefetcher) next() (*ListEntry, *entrySource, error) {
+	future, ok := <-p.queue
+	if !ok {
+		return nil, nil, io.EOF
+	}
+	e := <-future
+	return e.entry, e.src, e.err
+}
+
+// stop ends prefetching and closes all files that have been opened but not consumed.
+func (p *prefetcher) stop() {
+	close(p.done)
+	for future := range p.queue {
+		if e := <-future; e.src != nil {
+			e.src.close()
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package tarindex
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func writeTestTree(t *testing.T) string {
+	tdirName, err := ioutil.TempDir(os.TempDir(), "tarprefetch.")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	for i := 0; i < 50; i++ {
+		dir := path.Join(tdirName, fmt.Sprintf("dir%d", i%5))
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatalf("MkdirAll: %s", err)
+		}
+		f, err := os.Create(path.Join(dir, fmt.Sprintf("file%d", i)))
+		if err != nil {
+			t.Fatalf("Create: %s", err)
+		}
+		if _, err := io.Copy(f, io.LimitReader(rand.Reader, int64(i*i*i*7))); err != nil {
+			t.Fatalf("Copy: %s", err)
+		}
+		_ = f.Close()
+	}
+	return tdirName
+}
+
+func TestPrefetch(t *testing.T) {
+	tdirName := writeTestTree(t)
+	defer func() { _ = os.RemoveAll(tdirName) }()
+	idx := new(bytes.Buffer)
+	if err := WriteIndex(tdirName, idx); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+	writeTar := func(prefetch int, maxbytes int64) []byte {
+		buf := new(bytes.Buffer)
+		ir, err := NewIndexReader(bytes.NewReader(idx.Bytes()), buf, nil)
+		if err != nil {
+			t.Fatalf("NewIndexReader: %s", err)
+		}
+		ir.Prefetch = prefetch
+		if _, err := ir.SeekAndWrite("", 0, maxbytes); err != nil {
+			t.Fatalf("SeekAndWrite: %s", err)
+		}
+		return buf.Bytes()
+	}
+	td := writeTar(0, 0)
+	for _, prefetch := range []int{1, 4, 16} {
+		if td2 := writeTar(prefetch, 0); !bytes.Equal(td, td2) {
+			t.Errorf("Prefetch %d: Not equal", prefetch)
+		}
+		for _, maxbytes := range []int64{1, tarBlockSize, 10000, 100000} {
+			if td2 := writeTar(prefetch, maxbytes); !bytes.Equal(td[:maxbytes], td2) {
+				t.Errorf("Prefetch %d, maxbytes %d: Not equal", prefetch, maxbytes)
+			}
+		}
+	}
+}
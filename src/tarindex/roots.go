@@ -0,0 +1,145 @@
+package tarindex
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrRoots is returned if the source directories of an index cannot be combined.
+var ErrRoots = errors.New("invalid roots")
+
+// Root is a source directory of an index together with the path of its content in the tar stream.
+type Root struct {
+	Dir    string // Source directory.
+	Prefix string // Path in the tar stream, for example "./db".
+}
+
+// DirRoot returns the root of the source directory dir under the prefix "./<basename>". dir is taken literally, it
+// may contain "=".
+func DirRoot(dir string) Root {
+	name := path.Clean(dir)
+	if abs, err := filepath.Abs(dir); err == nil {
+		name = abs // The base name of "." is that of the current directory.
+	}
+	return Root{Dir: dir, Prefix: "./" + filepath.Base(name)}
+}
+
+// ParseRoot parses a source directory with its prefix, given as "<directory>=<prefix>". The directory ends at the
+// last "=", so prefixes cannot contain "=".
+func ParseRoot(value string) (Root, error) {
+	pos := strings.LastIndex(value, "=")
+	if pos <= 0 {
+		return Root{}, fmt.Errorf("%w: %q is not <directory>=<prefix>", ErrRoots, value)
+	}
+	return Root{Dir: value[:pos], Prefix: value[pos+1:]}, nil
+}
+
+// cleanPrefix returns prefix as a relative path starting with "./".
+func cleanPrefix(prefix string) string {
+	prefix = path.Clean("/" + prefix)
+	if prefix == "/" {
+		return "./"
+	}
+	return "." + prefix
+}
+
+// cleanRoots returns roots with absolute directories and cleaned prefixes. Directories must not contain each other,
+// prefixes must be unique.
+func cleanRoots(roots []Root) ([]Root, error) {
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("%w: no source directory", ErrRoots)
+	}
+	cleaned := make([]Root, len(roots))
+	prefixes := make(map[string]bool, len(roots))
+	for i, root := range roots {
+		dir, err := filepath.Abs(root.Dir)
+		if err != nil {
+			return nil, err
+		}
+		cleaned[i] = Root{Dir: dir, Prefix: cleanPrefix(root.Prefix)}
+		if strings.ContainsAny(cleaned[i].Dir+cleaned[i].Prefix, "\t\n") {
+			return nil, fmt.Errorf("%w: tab or newline in %s", ErrRoots, root.Dir)
+		}
+		if prefixes[cleaned[i].Prefix] {
+			return nil, fmt.Errorf("%w: duplicate prefix %s", ErrRoots, cleaned[i].Prefix)
+		}
+		prefixes[cleaned[i].Prefix] = true
+		for _, other := range cleaned[:i] {
+			if contains(other.Dir, cleaned[i].Dir) || contains(cleaned[i].Dir, other.Dir) {
+				return nil, fmt.Errorf("%w: %s and %s overlap", ErrRoots, other.Dir, cleaned[i].Dir)
+			}
+		}
+	}
+	return cleaned, nil
+}
+
+// contains returns true if name is dir or within dir. Both must be clean.
+func contains(dir, name string) bool {
+	return name == dir || strings.HasPrefix(name, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// rootsMetadata returns the index metadata that records roots.
+func rootsMetadata(roots []Root) Metadata {
+	lines := make([]string, len(roots))
+	for i, root := range roots {
+		lines[i] = root.Dir + "\t" + root.Prefix
+	}
+	return Metadata{MetaRoots: strings.Join(lines, "\n")}
+}
+
+// roots returns the source directories recorded in m, and false if the index has a single root.
+func (m Metadata) roots() ([]Root, bool) {
+	value, ok := m[MetaRoots]
+	if !ok {
+		return nil, false
+	}
+	var roots []Root
+	for _, line := range strings.Split(value, "\n") {
+		if pos := strings.IndexByte(line, '\t'); pos >= 0 {
+			roots = append(roots, Root{Dir: line[:pos], Prefix: line[pos+1:]})
+		}
+	}
+	return roots, true
+}
+
+// PathMods changes the path of entries with the PathMod whose BaseDir contains them, and with the longest BaseDir if
+// several do. Paths outside of all BaseDirs are not changed.
+type PathMods []PathMod
+
+func (mods PathMods) FixPath(orig string) string {
+	var match *PathMod
+	for i, mod := range mods {
+		if contains(path.Clean(mod.BaseDir), orig) && (match == nil || len(mod.BaseDir) > len(match.BaseDir)) {
+			match = &mods[i]
+		}
+	}
+	if match == nil {
+		return orig
+	}
+	return match.FixPath(orig)
+}
+
+// withSlash returns dir with a trailing slash.
+func withSlash(dir string) string {
+	if strings.HasSuffix(dir, "/") {
+		return dir
+	}
+	return dir + "/"
+}
+
+// IndexFixPath returns the function that changes the paths of entries of an index to their path in the tar stream.
+// dir is the root directory from the index header, meta the metadata of the index.
+func IndexFixPath(dir string, meta Metadata) func(string) string {
+	roots, ok := meta.roots()
+	if !ok {
+		return PathMod{BaseDir: dir, ModDir: "./"}.FixPath
+	}
+	mods := make(PathMods, len(roots))
+	for i, root := range roots {
+		mods[i] = PathMod{BaseDir: withSlash(root.Dir), ModDir: withSlash(root.Prefix)}
+	}
+	return mods.FixPath
+}
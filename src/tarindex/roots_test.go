@@ -0,0 +1,31 @@
+package tarindex
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRoot(t *testing.T) {
+	tests := []struct {
+		value string
+		root  Root
+		err   error
+	}{
+		{"/data/db=./db", Root{Dir: "/data/db", Prefix: "./db"}, nil},
+		{"/data/a=b=./ab", Root{Dir: "/data/a=b", Prefix: "./ab"}, nil},
+		{"/data/db=", Root{Dir: "/data/db", Prefix: ""}, nil},
+		{"/data/db", Root{}, ErrRoots},
+		{"=./db", Root{}, ErrRoots},
+	}
+	for _, test := range tests {
+		root, err := ParseRoot(test.value)
+		if root != test.root || !errors.Is(err, test.err) {
+			t.Errorf("ParseRoot(%q): %v, %v", test.value, root, err)
+		}
+	}
+	for dir, prefix := range map[string]string{"/data/a=b": "./a=b", "/data/key=./value/": "./value"} {
+		if root := DirRoot(dir); root.Dir != dir || root.Prefix != prefix {
+			t.Errorf("DirRoot(%q): %v", dir, root)
+		}
+	}
+}
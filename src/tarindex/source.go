@@ -0,0 +1,86 @@
+package tarindex
+
+import (
+	"io"
+	"os"
+)
+
+// entrySource holds the filesystem state required to write an entry: Its FileInfo, link target, open file and,
+// for small files, the already read content.
+type entrySource struct {
+	fi     os.FileInfo
+	link   string
+	f      *os.File
+	direct bool // f was opened with O_DIRECT.
+	data   []byte
+	err    error
+}
+
+// openEntry stats e and opens it if it is a regular file, or opens the tar file that contains it (SourceTar). Hard
+// links are not opened. Files of up to readAhead bytes are read completely.
+// Errors are returned as part of the entrySource.
+func (tw *TarWriter) openEntry(e *ListEntry, readAhead int64) *entrySource {
+	if tw.SourceTar != "" {
+		return tw.openTarRange(e, readAhead)
+	}
+	src := new(entrySource)
+	switch e.Type {
+	case EntryTypeDirectory:
+		if src.fi, src.err = os.Stat(e.Name); src.err == nil && !src.fi.IsDir() {
+			src.err = ErrIndexFSMismatch
+		}
+	case EntryTypeLink:
+		if src.fi, src.err = os.Lstat(e.Name); src.err == nil && !isLink(src.fi) {
+			src.err = ErrIndexFSMismatch
+		}
+		if src.err == nil {
+			src.link, src.err = os.Readlink(e.Name)
+		}
+	case EntryTypeHardlink:
+		if src.fi, src.err = os.Lstat(e.Name); src.err == nil && !isRegular(src.fi) {
+			src.err = ErrIndexFSMismatch
+		}
+		if src.err == nil && e.Meta[MetaLinkPath] == "" {
+			src.err = ErrIndexFSMismatch
+		}
+	case EntryTypeFifo, EntryTypeCharDevice, EntryTypeBlockDevice:
+		if src.fi, src.err = os.Lstat(e.Name); src.err == nil {
+			if t, ok := specialType(src.fi); !ok || t != e.Type {
+				src.err = ErrIndexFSMismatch
+			}
+		}
+	case EntryTypeFile:
+		if src.fi, src.err = os.Stat(e.Name); src.err == nil && !isRegular(src.fi) {
+			src.err = ErrIndexFSMismatch
+		}
+		if src.err == nil {
+			if tw.DirectIOSize > 0 && src.fi.Size() >= tw.DirectIOSize {
+				src.f, src.direct, src.err = openDirect(e.Name)
+			} else {
+				src.f, src.err = os.Open(e.Name)
+			}
+		}
+		if src.err == nil && tw.ReadAdvice && !src.direct {
+			_ = fadvise(src.f, 0, 0, fadvSequential)
+		}
+		if src.err == nil && !src.direct && src.fi.Size() <= readAhead {
+			src.data = make([]byte, src.fi.Size())
+			_, src.err = io.ReadFull(src.f, src.data)
+			if tw.ReadAdvice {
+				_ = fadvise(src.f, 0, 0, fadvDontNeed)
+			}
+			_ = src.f.Close()
+			src.f = nil
+		}
+	default:
+		src.err = ErrUnsupported
+	}
+	return src
+}
+
+func (src *entrySource) close() {
+	if src.f != nil {
+		_ = src.f.Close()
+		src.f = nil
+	}
+}
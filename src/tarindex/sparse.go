@@ -0,0 +1,110 @@
+package tarindex
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	paxGNUSparseSize      = "GNU.sparse.size"
+	paxGNUSparseNumBlocks = "GNU.sparse.numblocks"
+	paxGNUSparseMap       = "GNU.sparse.map"
+)
+
+// extent is a range of a sparse file that contains data.
+type extent struct {
+	offset, length int64
+}
+
+// extentsSize returns the number of data bytes in extents.
+func extentsSize(extents []extent) int64 {
+	var size int64
+	for _, ext := range extents {
+		size += ext.length
+	}
+	return size
+}
+
+// sparseMetadata returns the metadata of a sparse file of size bytes that contains data only in extents.
+func sparseMetadata(size int64, extents []extent) Metadata {
+	fields := make([]string, 0, len(extents)*2)
+	for _, ext := range extents {
+		fields = append(fields, strconv.FormatInt(ext.offset, 10), strconv.FormatInt(ext.length, 10))
+	}
+	return Metadata{
+		MetaSparseSize: strconv.FormatInt(size, 10),
+		MetaSparseMap:  strings.Join(fields, ","),
+	}
+}
+
+// sparse returns the size and data extents of a sparse file, and false if m does not describe a sparse file.
+func (m Metadata) sparse() (size int64, extents []extent, ok bool) {
+	sizeValue, ok := m[MetaSparseSize]
+	if !ok {
+		return 0, nil, false
+	}
+	size, err := strconv.ParseInt(sizeValue, 10, 64)
+	if err != nil {
+		return 0, nil, false
+	}
+	if m[MetaSparseMap] == "" {
+		return size, nil, true
+	}
+	fields := strings.Split(m[MetaSparseMap], ",")
+	if len(fields)%2 != 0 {
+		return 0, nil, false
+	}
+	extents = make([]extent, len(fields)/2)
+	for i := range extents {
+		if extents[i].offset, err = strconv.ParseInt(fields[2*i], 10, 64); err != nil {
+			return 0, nil, false
+		}
+		if extents[i].length, err = strconv.ParseInt(fields[2*i+1], 10, 64); err != nil {
+			return 0, nil, false
+		}
+	}
+	return size, extents, true
+}
+
+// sparsePAXRecords returns the PAX records of the GNU sparse format 0.1 for m, or nil if m does not describe a sparse
+// file. A file that ends with a hole gets a final empty extent, so that it is extended to its full size.
+func (m Metadata) sparsePAXRecords() []string {
+	size, extents, ok := m.sparse()
+	if !ok {
+		return nil
+	}
+	if n := len(extents); n == 0 || extents[n-1].offset+extents[n-1].length < size {
+		extents = append(extents, extent{offset: size})
+	}
+	fields := make([]string, 0, len(extents)*2)
+	for _, ext := range extents {
+		fields = append(fields, strconv.FormatInt(ext.offset, 10), strconv.FormatInt(ext.length, 10))
+	}
+	return []string{
+		formatMetaRecord(paxGNUSparseSize, strconv.FormatInt(size, 10)),
+		formatMetaRecord(paxGNUSparseNumBlocks, strconv.Itoa(len(extents))),
+		formatMetaRecord(paxGNUSparseMap, strings.Join(fields, ",")),
+	}
+}
+
+// copyExtents copies n bytes of the data in extents, starting at data offset skipbytes.
+func (tw *TarWriter) copyExtents(src *entrySource, extents []extent, skipbytes, n int64) (int64, error) {
+	var written int64
+	for _, ext := range extents {
+		if written >= n {
+			break
+		}
+		if skipbytes >= ext.length {
+			skipbytes -= ext.length
+			continue
+		}
+		length := minNotNegativeA(n-written, ext.length-skipbytes)
+		w, err := tw.copyContent(src, ext.offset+skipbytes, length)
+		written += w
+		if err != nil {
+			return written, err
+		}
+		skipbytes = 0
+	}
+	return written, nil
+}
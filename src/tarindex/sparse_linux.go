@@ -0,0 +1,52 @@
+//go:build linux
+// +build linux
+
+package tarindex
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+const (
+	seekData = 3 // SEEK_DATA
+	seekHole = 4 // SEEK_HOLE
+)
+
+// fileExtents returns the data extents of the file name, and false if it does not contain holes. It returns an error
+// if the file cannot be opened or searched for holes.
+func fileExtents(name string, fi os.FileInfo) ([]extent, bool, error) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || st.Blocks*512 >= fi.Size() {
+		return nil, false, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() { _ = f.Close() }()
+	size := fi.Size()
+	extents := make([]extent, 0, 4)
+	for offset := int64(0); offset < size; {
+		start, err := f.Seek(offset, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			break
+		} else if err != nil {
+			return nil, false, err
+		}
+		end, err := f.Seek(start, seekHole)
+		if err != nil {
+			return nil, false, err
+		}
+		if end > size {
+			end = size
+		}
+		extents = append(extents, extent{offset: start, length: end - start})
+		offset = end
+	}
+	if len(extents) == 1 && extents[0].offset == 0 && extents[0].length == size {
+		return nil, false, nil
+	}
+	return extents, true, nil
+}
@@ -0,0 +1,86 @@
+package tarindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSparse(t *testing.T) {
+	tdirName, err := ioutil.TempDir(os.TempDir(), "tarsparse.")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer func() { _ = os.RemoveAll(tdirName) }()
+	name := path.Join(tdirName, "sparse")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	const size = 16 << 20
+	for _, offset := range []int64{1 << 20, 5<<20 + 100} {
+		if _, err := f.WriteAt(bytes.Repeat([]byte{0x55}, 70000), offset); err != nil {
+			t.Fatalf("WriteAt: %s", err)
+		}
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatalf("Truncate: %s", err)
+	}
+	_ = f.Close()
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+
+	var entry *ListEntry
+	entryFunc := func(e *ListEntry) error {
+		if e.Name == name {
+			entry = e
+		}
+		return nil
+	}
+	if err := ListToFunc(tdirName, entryFunc, OptSparse); err != nil {
+		t.Fatalf("ListToFunc: %s", err)
+	}
+	if _, _, ok := entry.Meta.sparse(); !ok {
+		t.Skip("Holes not detected")
+	}
+	full := new(bytes.Buffer)
+	n, err := NewTarWriter(full).WriteEntry(entry, 0, -1)
+	if err != nil {
+		t.Fatalf("WriteEntry: %s", err)
+	}
+	if n != entry.TarSize() || n >= size {
+		t.Errorf("Written %d, expected %d", n, entry.TarSize())
+	}
+	for _, skip := range []int64{0, 700, 1100, n / 2, n - 10} {
+		for _, max := range []int64{-1, 1, 3000, 100000} {
+			part := new(bytes.Buffer)
+			if _, err := NewTarWriter(part).WriteEntry(entry, skip, max); err != nil {
+				t.Fatalf("WriteEntry %d %d: %s", skip, max, err)
+			}
+			if expect := maxBytes(full.Bytes()[skip:], max); !bytes.Equal(part.Bytes(), expect) {
+				t.Errorf("Skip %d, max %d: %d bytes, expected %d", skip, max, part.Len(), len(expect))
+			}
+		}
+	}
+	tr := tar.NewReader(io.MultiReader(full, bytes.NewReader(make([]byte, tarFooterSize))))
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatalf("Next: %s", err)
+	}
+	if hdr.Name != name || hdr.Size != size {
+		t.Errorf("Header: %s %d", hdr.Name, hdr.Size)
+	}
+	data, err := ioutil.ReadAll(tr)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Content differs")
+	}
+}
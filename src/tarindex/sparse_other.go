@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package tarindex
+
+import (
+	"os"
+)
+
+// fileExtents is not supported, files are never treated as sparse.
+func fileExtents(name string, fi os.FileInfo) ([]extent, bool, error) {
+	return nil, false, nil
+}
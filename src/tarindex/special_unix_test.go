@@ -0,0 +1,75 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package tarindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+)
+
+func TestSpecialFiles(t *testing.T) {
+	tdirName, err := ioutil.TempDir(os.TempDir(), "tarspecial.")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer func() { _ = os.RemoveAll(tdirName) }()
+	if err := syscall.Mkfifo(path.Join(tdirName, "fifo"), 0600); err != nil {
+		t.Fatalf("Mkfifo: %s", err)
+	}
+	l, err := net.Listen("unix", path.Join(tdirName, "socket"))
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer func() { _ = l.Close() }()
+
+	skipped := make(map[string]error)
+	report := OptReportSkipped(func(name string, err error) {
+		skipped[path.Base(name)] = err
+	})
+	if names := listNames(t, tdirName, report); len(names) != 1 {
+		t.Errorf("Listed without OptSpecialFiles: %v", names)
+	}
+	if len(skipped) != 2 || skipped["fifo"] != ErrSpecialFile || skipped["socket"] != ErrUnsupported {
+		t.Errorf("Skipped: %v", skipped)
+	}
+
+	idx := new(bytes.Buffer)
+	if err := WriteIndex(tdirName, idx, OptSpecialFiles); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+	buf := new(bytes.Buffer)
+	ir, err := NewIndexReader(bytes.NewReader(idx.Bytes()), buf, nil)
+	if err != nil {
+		t.Fatalf("NewIndexReader: %s", err)
+	}
+	if _, err := ir.SeekAndWrite("", 0, 0); err != nil {
+		t.Fatalf("SeekAndWrite: %s", err)
+	}
+	if buf.Len() != int(tarHeaderSize*2+tarFooterSize) {
+		t.Errorf("Size: %d", buf.Len())
+	}
+	tr := tar.NewReader(buf)
+	var fifos int
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next: %s", err)
+		}
+		if hdr.Typeflag == tar.TypeFifo && path.Base(hdr.Name) == "fifo" {
+			fifos++
+		}
+	}
+	if fifos != 1 {
+		t.Errorf("FIFO entries: %d", fifos)
+	}
+}
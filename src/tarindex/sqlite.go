@@ -0,0 +1,83 @@
+package tarindex
+
+import (
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"os"
+
+	_ "modernc.org/sqlite" // Registers the database/sql driver "sqlite".
+)
+
+// exportSQLite writes a SQLite database with the tables of ExportSQL to w. SQLite cannot write to a stream, so the
+// database is created in a temporary file first.
+func exportSQLite(r io.ReadSeeker, w io.Writer, root string, size int64, meta Metadata,
+	fixPath func(string) string) error {
+	tmp, err := ioutil.TempFile("", "tarindex.*.sqlite")
+	if err != nil {
+		return err
+	}
+	name := tmp.Name()
+	defer func() { _ = os.Remove(name) }()
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := writeSQLiteFile(r, name, root, size, meta, fixPath); err != nil {
+		return err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// writeSQLiteFile creates the tables of ExportSQL in the SQLite database dbFile and fills them in one transaction.
+func writeSQLiteFile(r io.ReadSeeker, dbFile, root string, size int64, meta Metadata,
+	fixPath func(string) string) error {
+	db, err := sql.Open("sqlite", dbFile)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(exportSchema); err != nil {
+		return err
+	}
+	keys, values := indexMetaRows(root, size, meta)
+	for _, key := range keys {
+		if _, err := tx.Exec("INSERT INTO index_meta VALUES (?, ?)", key, values[key]); err != nil {
+			return err
+		}
+	}
+	insert, err := tx.Prepare("INSERT INTO entries VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = insert.Close() }()
+	if err := ReadIndex(r, func(e *ListEntry) error {
+		entry := newExportEntry(e, fixPath)
+		meta, err := entry.metaJSON()
+		if err != nil {
+			return err
+		}
+		var metaValue interface{}
+		if meta != "" {
+			metaValue = meta
+		}
+		_, err = insert.Exec(entry.Path, entry.Dir, entry.Type, entry.Size, entry.FirstByte, entry.LastByte, metaValue)
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return db.Close()
+}
@@ -0,0 +1,165 @@
+package tarindex
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DeleteFileName is the name of the postfix file that lists paths the receiver should delete after a sync.
+const DeleteFileName = ".delete"
+
+// ErrManifestPath is returned for manifest paths outside of the snapshot, which would be listed for deletion.
+var ErrManifestPath = errors.New("manifest path outside of snapshot")
+
+// ManifestEntry describes a filesystem object that a receiver already has.
+type ManifestEntry struct {
+	Path   string `json:"path"`             // Path relative to the snapshot root, as contained in the tar.
+	Size   int64  `json:"size"`             // Size of a regular file.
+	MTime  int64  `json:"mtime,omitempty"`  // Modification time in seconds since the epoch. Ignored if 0.
+	Digest string `json:"digest,omitempty"` // Hex encoded SHA256 of a regular file. Ignored if empty.
+}
+
+// Manifest is the content of a receiver, keyed by cleaned path.
+type Manifest map[string]*ManifestEntry
+
+func manifestPath(name string) string {
+	return path.Clean(strings.TrimPrefix(path.Clean(name), "/"))
+}
+
+// ReadManifest reads a manifest of JSON objects, one per line, from r. Paths that lead out of the snapshot with ".."
+// are rejected with ErrManifestPath.
+func ReadManifest(r io.Reader) (Manifest, error) {
+	manifest := make(Manifest)
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		e := new(ManifestEntry)
+		if err := dec.Decode(e); err != nil {
+			if err == io.EOF {
+				return manifest, nil
+			}
+			return nil, err
+		}
+		name := manifestPath(e.Path)
+		if name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("%w: %s", ErrManifestPath, e.Path)
+		}
+		manifest[name] = e
+	}
+}
+
+func fileDigest(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// needsSync returns true if the receiver's copy m of e is missing or differs from the filesystem. Size, modification
+// time and digest recorded in the index take precedence over the filesystem. Entries of an index of a tar file
+// (fromTar) are sent if the receiver has a digest but the index does not.
+func needsSync(e *ListEntry, m *ManifestEntry, fromTar bool) (bool, error) {
+	if m == nil {
+		return true, nil
+	}
+	if e.Type == EntryTypeDirectory {
+		return false, nil
+	}
+	size, mtime, ok := e.Meta.stat()
+	if !ok {
+		fi, err := os.Lstat(e.Name)
+		if err != nil {
+			return false, err
+		}
+		size, mtime = fi.Size(), fi.ModTime().Unix()
+	}
+	if m.MTime != 0 && fixModTime(time.Unix(mtime, 0)).Unix() != m.MTime {
+		return true, nil
+	}
+	if e.Type != EntryTypeFile && e.Type != EntryTypeHardlink {
+		return false, nil
+	}
+	if size != m.Size {
+		return true, nil
+	}
+	if m.Digest == "" {
+		return false, nil
+	}
+	digest := e.Meta[MetaDigest]
+	if digest == "" && fromTar {
+		return true, nil
+	} else if digest == "" {
+		var err error
+		if digest, err = fileDigest(e.Name); err != nil {
+			return false, err
+		}
+	}
+	return !strings.EqualFold(digest, m.Digest), nil
+}
+
+// WriteSync writes a tar stream that contains only the entries that are missing from manifest or differ from it.
+// Paths that are contained in manifest but not in the index are listed, one per line, in a postfix file called
+// DeleteFileName. The postfix file of the IndexReader is appended last. WriteSync cannot be combined with seeking.
+func (ir *IndexReader) WriteSync(manifest Manifest) (int64, error) {
+	var written int64
+	if ir.noMoreSeek {
+		return 0, ErrNoSeek
+	}
+	ir.noMoreSeek = true
+	seen := make(map[string]bool, len(manifest))
+IndexLoop:
+	for {
+		entry, err := ir.s.next()
+		if err != nil {
+			if err == io.EOF {
+				break IndexLoop
+			}
+			return written, err
+		}
+		name := manifestPath(ir.w.FixPath(entry.Name))
+		seen[name] = true
+		send, err := needsSync(entry, manifest[name], ir.w.SourceTar != "")
+		if err != nil {
+			return written, err
+		}
+		if !send {
+			continue IndexLoop
+		}
+		n, err := ir.w.WriteEntry(entry, 0, -1)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	deletes := make([]string, 0)
+	for name := range manifest {
+		if !seen[name] {
+			deletes = append(deletes, "./"+name)
+		}
+	}
+	if len(deletes) > 0 {
+		sort.Strings(deletes)
+		n, err := ir.w.AddPostfixFile(DeleteFileName, []byte(strings.Join(deletes, "\n")+"\n"), 0, -1)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	n, err := ir.writeTrailer()
+	return written + n, err
+}
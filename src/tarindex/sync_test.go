@@ -0,0 +1,92 @@
+package tarindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWriteSync(t *testing.T) {
+	tdirName, err := ioutil.TempDir(os.TempDir(), "tarsync.")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer func() { _ = os.RemoveAll(tdirName) }()
+	for name, content := range map[string]string{"same": "same", "changed": "changed", "new": "new"} {
+		if err := ioutil.WriteFile(path.Join(tdirName, name), []byte(content), 0600); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+	idx := new(bytes.Buffer)
+	if err := WriteIndex(tdirName, idx); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+	fi, _ := os.Stat(path.Join(tdirName, "same"))
+	manifest, err := ReadManifest(strings.NewReader(`{"path":"./"}
+{"path":"./same","size":4,"mtime":` + strconv.FormatInt(fi.ModTime().Unix(), 10) + `}
+{"path":"changed","size":4}
+{"path":"./gone/file","size":1}
+`))
+	if err != nil {
+		t.Fatalf("ReadManifest: %s", err)
+	}
+	buf := new(bytes.Buffer)
+	ir, err := NewIndexReader(idx, buf, &PostfixFile{Name: ".version", Content: []byte("test")})
+	if err != nil {
+		t.Fatalf("NewIndexReader: %s", err)
+	}
+	n, err := ir.WriteSync(manifest)
+	if err != nil {
+		t.Fatalf("WriteSync: %s", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("Wrong size reported: %d != %d", n, buf.Len())
+	}
+	names := make([]string, 0)
+	tr := tar.NewReader(buf)
+	for hdr, err := tr.Next(); err == nil; hdr, err = tr.Next() {
+		names = append(names, path.Clean(hdr.Name))
+		if hdr.Name == DeleteFileName {
+			d, _ := io.ReadAll(tr)
+			if string(d) != "./gone/file\n" {
+				t.Errorf("Wrong delete list: %q", d)
+			}
+		}
+	}
+	got := strings.Join(names, ",")
+	if strings.Contains(got, "same") || !strings.Contains(got, "changed") || !strings.Contains(got, "new") ||
+		!strings.HasSuffix(got, DeleteFileName+",.version") {
+		t.Errorf("Wrong entries: %s", got)
+	}
+}
+
+func TestReadManifestPaths(t *testing.T) {
+	for _, test := range []struct {
+		path string
+		name string
+	}{
+		{"./a/b", "a/b"},
+		{"/a/../b", "b"},
+		{"a/..", "."},
+		{"..", ""},
+		{"../x", ""},
+		{"./a/../../x", ""},
+	} {
+		manifest, err := ReadManifest(strings.NewReader(fmt.Sprintf(`{"path": %q, "size": 1}`, test.path)))
+		if test.name == "" {
+			if !errors.Is(err, ErrManifestPath) {
+				t.Errorf("%s: %v", test.path, err)
+			}
+		} else if err != nil || manifest[test.name] == nil {
+			t.Errorf("%s: %v, %v", test.path, manifest, err)
+		}
+	}
+}
@@ -3,18 +3,26 @@ package tarindex
 import (
 	"archive/tar"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"time"
+	"unsafe"
 )
 
 // Required for testing: Replace with func(x time.Time) time.Time { return time.Time{} }
 var fixModTime = func(t time.Time) time.Time { return t }
 
+// postfixModTime is the modification time of postfix files. It is fixed, so that the same tar stream is produced
+// on every request and downloads can be resumed.
+var postfixModTime = time.Unix(0, 0)
+
 var (
 	ErrIndexFSMismatch = errors.New("index does not match filesystem")
 	ErrUnsupported     = errors.New("unsupported filetype")
 	ErrSkipBoundary    = errors.New("skip beyond file boundary")
+	ErrSpecialFile     = errors.New("special files not enabled")
+	ErrSizeChanged     = errors.New("file size changed since indexing")
 )
 
 func minNotNegativeA(a, b int64) int64 {
@@ -32,15 +40,51 @@ func maxBytes(d []byte, maxbytes int64) []byte {
 	return d[:l]
 }
 
+const (
+	defaultBufferSize = 1024 * 1024
+	directIOAlign     = 4096
+)
+
 type TarWriter struct {
 	w       io.Writer
 	FixPath func(string) string
+	// NoZeroCopy forces file content through a user space buffer, even if w can read directly from files.
+	NoZeroCopy bool
+	// ReadAdvice tells the kernel that files are read sequentially and that their content is not needed in the page
+	// cache after it has been written (posix_fadvise). Files are copied in windows of BufferSize that are dropped from
+	// the page cache as soon as they have been written. Only supported on Linux.
+	ReadAdvice bool
+	// BufferSize is the size of the buffer used when file content passes through user space. Defaults to 1MiB.
+	BufferSize int
+	// DirectIOSize is the size from which on files are read with O_DIRECT, bypassing the page cache. Files read with
+	// O_DIRECT always pass through user space. 0 disables O_DIRECT.
+	DirectIOSize int64
+	// KeepIndexedSize writes files whose size changed since indexing with their indexed size, truncated or padded
+	// with zeros, instead of failing with ErrSizeChanged. See Changed. Unless the index records exact sizes
+	// (OptStat), the indexed size is rounded up to the tar block size.
+	KeepIndexedSize bool
+	// SourceTar is the tar file that contains the entries, if they have been indexed with WriteTarIndex. The bytes of
+	// entries are copied from it, instead of reading the filesystem.
+	SourceTar string
+
+	buf     []byte
+	changed []string
+}
+
+// writerOnly hides all methods of an io.Writer except Write.
+type writerOnly struct {
+	io.Writer
 }
 
 func NewTarWriter(w io.Writer) *TarWriter {
 	return &TarWriter{w: w}
 }
 
+// Changed returns the names of the files that were written with their indexed size because their size changed.
+func (tw *TarWriter) Changed() []string {
+	return tw.changed
+}
+
 func (tw *TarWriter) fixPath(path string) string {
 	if tw.FixPath == nil {
 		return path
@@ -63,33 +107,84 @@ func (tw *TarWriter) fixLink(link string) string {
 
 // WriteEntry writes e's tar entry (header and content) to w. It skips the first skipbytes bytes.
 func (tw *TarWriter) WriteEntry(e *ListEntry, skipbytes, maxbytes int64) (int64, error) {
+	return tw.writeEntry(e, tw.openEntry(e, 0), skipbytes, maxbytes)
+}
+
+// writeEntry writes e from the already opened src and closes src.
+func (tw *TarWriter) writeEntry(e *ListEntry, src *entrySource, skipbytes, maxbytes int64) (int64, error) {
+	defer src.close()
+	if src.err != nil {
+		return 0, src.err
+	}
+	if skipbytes < 0 {
+		skipbytes = 0
+	}
+	if tw.SourceTar != "" {
+		return tw.writeTarRange(e, src, skipbytes, maxbytes)
+	}
+	pax, err := e.Meta.paxHeader()
+	if err != nil {
+		return 0, err
+	}
+	var written int64
+	if skipbytes < int64(len(pax)) {
+		n, err := tw.w.Write(maxBytes(pax[skipbytes:], maxbytes))
+		written = int64(n)
+		if err != nil || written == maxbytes {
+			return written, err
+		}
+		if maxbytes > 0 {
+			maxbytes -= written
+		}
+		skipbytes = 0
+	} else {
+		skipbytes -= int64(len(pax))
+	}
+	n, err := tw.writeTypedEntry(e, src, skipbytes, maxbytes)
+	return written + n, err
+}
+
+// writeTypedEntry writes the header and content of e, which follow its extended header.
+func (tw *TarWriter) writeTypedEntry(e *ListEntry, src *entrySource, skipbytes, maxbytes int64) (int64, error) {
 	switch e.Type {
 	case EntryTypeDirectory:
-		return tw.writeDirectoryEntry(e, skipbytes, maxbytes)
+		return tw.writeDirectoryEntry(e, src, skipbytes, maxbytes)
 	case EntryTypeLink:
-		return tw.writeLinkEntry(e, skipbytes, maxbytes)
+		return tw.writeLinkEntry(e, src, skipbytes, maxbytes)
+	case EntryTypeHardlink:
+		return tw.writeHardlinkEntry(e, src, skipbytes, maxbytes)
+	case EntryTypeFifo, EntryTypeCharDevice, EntryTypeBlockDevice:
+		return tw.writeSpecialEntry(e, src, skipbytes, maxbytes)
 	case EntryTypeFile:
-		return tw.writeFileEntry(e, skipbytes, maxbytes)
+		return tw.writeFileEntry(e, src, skipbytes, maxbytes)
 	default:
 		return 0, ErrUnsupported
 	}
 }
 
-func (tw *TarWriter) writeDirectoryEntry(e *ListEntry, skipbytes, maxbytes int64) (int64, error) {
+func (tw *TarWriter) writeDirectoryEntry(e *ListEntry, src *entrySource, skipbytes, maxbytes int64) (int64, error) {
 	if skipbytes < 0 {
 		skipbytes = 0
 	}
 	if skipbytes > tarHeaderSize {
 		panic("Directory with skipbytes>tarHeaderBytesFromFileInfo")
 	}
-	fi, err := os.Stat(e.Name)
+	hdr, err := tarHeaderBytesFromFileInfo(e, src.fi, "", tw.fixHeader)
 	if err != nil {
 		return 0, err
 	}
-	if !fi.IsDir() {
-		return 0, ErrIndexFSMismatch
+	n, err := tw.w.Write(maxBytes(hdr[skipbytes:], maxbytes))
+	return int64(n), err
+}
+
+func (tw *TarWriter) writeLinkEntry(e *ListEntry, src *entrySource, skipbytes, maxbytes int64) (int64, error) {
+	if skipbytes < 0 {
+		skipbytes = 0
+	}
+	if skipbytes > tarHeaderSize {
+		panic("Link with skipbytes>tarHeaderBytesFromFileInfo")
 	}
-	hdr, err := tarHeaderBytesFromFileInfo(e, fi, "", tw.fixHeader)
+	hdr, err := tarHeaderBytesFromFileInfo(e, src.fi, tw.fixLink(src.link), tw.fixHeader)
 	if err != nil {
 		return 0, err
 	}
@@ -97,25 +192,39 @@ func (tw *TarWriter) writeDirectoryEntry(e *ListEntry, skipbytes, maxbytes int64
 	return int64(n), err
 }
 
-func (tw *TarWriter) writeLinkEntry(e *ListEntry, skipbytes, maxbytes int64) (int64, error) {
+// writeHardlinkEntry writes a header-only entry that links to the earlier entry e.Meta[MetaLinkPath].
+func (tw *TarWriter) writeHardlinkEntry(e *ListEntry, src *entrySource, skipbytes, maxbytes int64) (int64, error) {
 	if skipbytes < 0 {
 		skipbytes = 0
 	}
 	if skipbytes > tarHeaderSize {
-		panic("Link with skipbytes>tarHeaderBytesFromFileInfo")
+		panic("Hardlink with skipbytes>tarHeaderBytesFromFileInfo")
 	}
-	fi, err := os.Lstat(e.Name)
+	header, err := tar.FileInfoHeader(src.fi, "")
 	if err != nil {
 		return 0, err
 	}
-	if !isLink(fi) {
-		return 0, ErrIndexFSMismatch
-	}
-	link, err := os.Readlink(e.Name)
+	header.Name = e.Name
+	header.Typeflag = tar.TypeLink
+	header.Linkname = tw.fixLink(e.Meta[MetaLinkPath])
+	header.Size = 0
+	hdr, err := tarHeaderBytes(header, tw.fixHeader)
 	if err != nil {
 		return 0, err
 	}
-	hdr, err := tarHeaderBytesFromFileInfo(e, fi, tw.fixLink(link), tw.fixHeader)
+	n, err := tw.w.Write(maxBytes(hdr[skipbytes:], maxbytes))
+	return int64(n), err
+}
+
+// writeSpecialEntry writes the header-only entry of a FIFO or device.
+func (tw *TarWriter) writeSpecialEntry(e *ListEntry, src *entrySource, skipbytes, maxbytes int64) (int64, error) {
+	if skipbytes < 0 {
+		skipbytes = 0
+	}
+	if skipbytes > tarHeaderSize {
+		panic("Special file with skipbytes>tarHeaderBytesFromFileInfo")
+	}
+	hdr, err := tarHeaderBytesFromFileInfo(e, src.fi, "", tw.fixHeader)
 	if err != nil {
 		return 0, err
 	}
@@ -123,6 +232,164 @@ func (tw *TarWriter) writeLinkEntry(e *ListEntry, skipbytes, maxbytes int64) (in
 	return int64(n), err
 }
 
+// buffer returns the user space buffer. It is aligned for O_DIRECT.
+func (tw *TarWriter) buffer() []byte {
+	if tw.buf == nil {
+		size := tw.BufferSize
+		if size <= 0 {
+			size = defaultBufferSize
+		}
+		if r := size % directIOAlign; r != 0 {
+			size += directIOAlign - r
+		}
+		tw.buf = alignedBuffer(size, directIOAlign)
+	}
+	return tw.buf
+}
+
+func alignedBuffer(size, align int) []byte {
+	buf := make([]byte, size+align)
+	offset := 0
+	if r := int(uintptr(unsafe.Pointer(&buf[0])) % uintptr(align)); r != 0 {
+		offset = align - r
+	}
+	return buf[offset : offset+size]
+}
+
+// copyFile copies n bytes starting at offset of src to the tar stream. If the underlying writer implements
+// io.ReaderFrom (net.TCPConn, os.File, http.ResponseWriter with Content-Length) it receives the file itself, limited
+// by an io.LimitedReader, so that the runtime can use sendfile, splice or copy_file_range and the content never
+// passes through user space.
+func (tw *TarWriter) copyFile(src *entrySource, offset, n int64) (int64, error) {
+	if src.direct {
+		return tw.copyDirect(src.f, offset, n)
+	}
+	if _, err := src.f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if !tw.ReadAdvice {
+		return tw.copyRange(src.f, n)
+	}
+	window := int64(tw.BufferSize)
+	if window <= 0 {
+		window = defaultBufferSize
+	}
+	var written int64
+	for written < n {
+		size := minNotNegativeA(window, n-written)
+		_ = fadvise(src.f, offset+written, size, fadvWillNeed)
+		w, err := tw.copyRange(src.f, size)
+		if w > 0 {
+			_ = fadvise(src.f, offset+written, w, fadvDontNeed)
+		}
+		written += w
+		if err != nil || w < size {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// copyRange copies n bytes from the current position of f to the tar stream, see copyFile.
+func (tw *TarWriter) copyRange(f *os.File, n int64) (int64, error) {
+	lr := &io.LimitedReader{R: f, N: n}
+	if rf, ok := tw.w.(io.ReaderFrom); ok && !tw.NoZeroCopy {
+		return rf.ReadFrom(lr)
+	}
+	return io.CopyBuffer(writerOnly{tw.w}, lr, tw.buffer())
+}
+
+// copyDirect copies n bytes starting at offset from f, which is opened with O_DIRECT. Reads start at aligned
+// offsets into an aligned buffer, bytes before offset are discarded.
+func (tw *TarWriter) copyDirect(f *os.File, offset, n int64) (int64, error) {
+	var written int64
+	buf := tw.buffer()
+	pos := offset - offset%directIOAlign
+	skip := int(offset - pos)
+	for written < n {
+		r, err := f.ReadAt(buf, pos)
+		if r > skip {
+			d := maxBytes(buf[skip:r], n-written)
+			w, err := tw.w.Write(d)
+			written += int64(w)
+			if err != nil {
+				return written, err
+			}
+		}
+		if err == io.EOF && written < n {
+			return written, io.ErrUnexpectedEOF
+		} else if err != nil && err != io.EOF {
+			return written, err
+		}
+		pos += int64(r)
+		skip = 0
+	}
+	return written, nil
+}
+
+// indexedSize returns the size of file e as recorded in the index, and true if fileSize differs from it. If the exact
+// size has not been recorded, fileSize is accepted as long as it results in the same entry size. Entries that have
+// not been read from an index are accepted with any size.
+func indexedSize(e *ListEntry, fileSize int64) (int64, bool) {
+	if size, _, ok := e.Meta.stat(); ok {
+		return size, size != fileSize
+	}
+	if size, _, ok := e.Meta.sparse(); ok {
+		return size, size != fileSize
+	}
+	if e.LastByte == 0 {
+		return fileSize, false
+	}
+	content := e.Size - tarHeaderSize - e.Meta.paxHeaderSize()
+	if paddedTarBlockSize(fileSize) == content {
+		return fileSize, false
+	}
+	return content, true
+}
+
+// copyContent copies n bytes starting at offset of the file. Bytes beyond the end of the file are written as zeros,
+// if KeepIndexedSize is set.
+func (tw *TarWriter) copyContent(src *entrySource, offset, n int64) (int64, error) {
+	var written int64
+	var err error
+	if available := minNotNegativeA(n, src.fi.Size()-offset); available > 0 {
+		if src.data != nil {
+			var w int
+			w, err = tw.w.Write(src.data[offset : offset+available])
+			written = int64(w)
+		} else {
+			written, err = tw.copyFile(src, offset, available)
+		}
+		if err == io.ErrUnexpectedEOF && tw.KeepIndexedSize {
+			err = nil // The file shrank while it was read (O_DIRECT), it is padded like a file that shrank before.
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	if written < n {
+		if !tw.KeepIndexedSize {
+			return written, io.ErrUnexpectedEOF
+		}
+		z, err := tw.writeZeros(n - written)
+		return written + z, err
+	}
+	return written, nil
+}
+
+// writeZeros writes n zero bytes.
+func (tw *TarWriter) writeZeros(n int64) (int64, error) {
+	var written int64
+	for written < n {
+		w, err := tw.w.Write(maxBytes(zeroBlock[:], n-written))
+		written += int64(w)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
 func paddingSize(size int64) int64 {
 	r := size % tarBlockSize
 	if r == 0 {
@@ -132,31 +399,40 @@ func paddingSize(size int64) int64 {
 	return r
 }
 
-func (tw *TarWriter) writeFileEntry(e *ListEntry, skipbytes, maxbytes int64) (int64, error) {
+func (tw *TarWriter) writeFileEntry(e *ListEntry, src *entrySource, skipbytes, maxbytes int64) (int64, error) {
 	var nHeader, nBody, nPad int64
+	var err error
 	if skipbytes < 0 {
 		skipbytes = 0
 	}
-	fi, err := os.Stat(e.Name)
-	if err != nil {
-		return 0, err
-	}
-	if !isRegular(fi) {
-		return 0, ErrIndexFSMismatch
+	fileSize := src.fi.Size()
+	size, changed := indexedSize(e, fileSize)
+	if changed {
+		if !tw.KeepIndexedSize {
+			return 0, fmt.Errorf("%w: %s", ErrSizeChanged, e.Name)
+		}
+		tw.changed = append(tw.changed, e.Name)
+	}
+	// The tar entry of sparse files only contains the data extents.
+	_, extents, sparse := e.Meta.sparse()
+	bodySize := size
+	if sparse {
+		bodySize = extentsSize(extents)
+	}
+	fixHeader := tw.fixHeader
+	if bodySize != fileSize {
+		fixHeader = func(hdr *tar.Header) {
+			tw.fixHeader(hdr)
+			hdr.Size = bodySize
+		}
 	}
-	fileSize := fi.Size()
-	pad := paddingSize(fileSize)
-	if tarHeaderSize+fileSize+pad < skipbytes {
+	pad := paddingSize(bodySize)
+	if tarHeaderSize+bodySize+pad < skipbytes {
 		return 0, ErrSkipBoundary
 	}
-	f, err := os.Open(e.Name)
-	if err != nil {
-		return 0, err
-	}
-	defer func() { _ = f.Close() }()
 	if skipbytes <= tarHeaderSize {
 		var n int
-		hdr, err := tarHeaderBytesFromFileInfo(e, fi, "", tw.fixHeader)
+		hdr, err := tarHeaderBytesFromFileInfo(e, src.fi, "", fixHeader)
 		if err != nil {
 			return 0, err
 		}
@@ -172,11 +448,12 @@ func (tw *TarWriter) writeFileEntry(e *ListEntry, skipbytes, maxbytes int64) (in
 	} else if skipbytes > 0 {
 		skipbytes -= tarHeaderSize
 	}
-	if skipbytes <= fileSize {
-		if _, err := f.Seek(skipbytes, io.SeekStart); err != nil {
-			return nHeader, err
+	if skipbytes <= bodySize {
+		if sparse {
+			nBody, err = tw.copyExtents(src, extents, skipbytes, minNotNegativeA(maxbytes, bodySize-skipbytes))
+		} else {
+			nBody, err = tw.copyContent(src, skipbytes, minNotNegativeA(maxbytes, bodySize-skipbytes))
 		}
-		nBody, err = io.Copy(tw.w, io.LimitReader(f, maxbytes))
 		if err != nil {
 			return nBody + nHeader, err
 		}
@@ -186,7 +463,7 @@ func (tw *TarWriter) writeFileEntry(e *ListEntry, skipbytes, maxbytes int64) (in
 		}
 		skipbytes = 0
 	} else {
-		skipbytes -= fileSize
+		skipbytes -= bodySize
 	}
 	if pad > 0 {
 		var n int
@@ -216,15 +493,12 @@ func (tw *TarWriter) AddPostfixFile(name string, content []byte, skipbytes, maxb
 	fileSize := int64(len(content))
 	if skipbytes <= tarHeaderSize {
 		var n int
-		now := time.Now()
 		header := &tar.Header{
-			Name:       name,
-			Typeflag:   tar.TypeReg,
-			Size:       fileSize,
-			Mode:       int64(0600),
-			ModTime:    now,
-			AccessTime: now,
-			ChangeTime: now,
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Size:     fileSize,
+			Mode:     int64(0600),
+			ModTime:  postfixModTime,
 		}
 		hdr, err := tarHeaderBytes(header, tw.fixHeader)
 		if err != nil {
@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path"
 	"testing"
@@ -62,7 +63,7 @@ func TestLink(t *testing.T) {
 		buf := new(bytes.Buffer)
 		tarW := NewTarWriter(buf)
 		entry := mkListEntry(tdirName, fi)
-		if _, err := tarW.writeLinkEntry(entry, skipbytes, 100000); err != nil {
+		if _, err := tarW.writeLinkEntry(entry, tarW.openEntry(entry, 0), skipbytes, 100000); err != nil {
 			t.Fatalf("writeLinkEntry: %s", err)
 		}
 		_, _ = tarW.Close(0, 100000)
@@ -99,7 +100,7 @@ func TestDir(t *testing.T) {
 		buf := new(bytes.Buffer)
 		tarW := NewTarWriter(buf)
 		entry := mkListEntry(tdirName, fi)
-		if _, err := tarW.writeDirectoryEntry(entry, skipbytes, 100000); err != nil {
+		if _, err := tarW.writeDirectoryEntry(entry, tarW.openEntry(entry, 0), skipbytes, 100000); err != nil {
 			t.Fatalf("writeDirectoryEntry: %s", err)
 		}
 		_, _ = tarW.Close(0, 100000)
@@ -140,9 +141,11 @@ func TestFile(t *testing.T) {
 		buf := new(bytes.Buffer)
 		tarW := NewTarWriter(buf)
 		entry := mkListEntry(os.TempDir(), fi)
-		if _, err := tarW.writeFileEntry(entry, skipbytes, 100000); err != nil {
+		src := tarW.openEntry(entry, 0)
+		if _, err := tarW.writeFileEntry(entry, src, skipbytes, 100000); err != nil {
 			t.Fatalf("writeFileEntry: %s", err)
 		}
+		src.close()
 		_, _ = tarW.Close(0, 100000)
 		td2 := buf.Bytes()
 		if len(td[skipbytes:]) != len(td2) {
@@ -153,3 +156,204 @@ func TestFile(t *testing.T) {
 		}
 	}
 }
+
+func benchmarkWriteFileEntry(b *testing.B, noZeroCopy bool) {
+	const size = 64 << 20
+	f, err := ioutil.TempFile(os.TempDir(), "tarWriter.")
+	if err != nil {
+		b.Fatalf("TempFile: %s", err)
+	}
+	name := f.Name()
+	defer func() { _ = os.Remove(name) }()
+	if _, err := io.Copy(f, io.LimitReader(rand.Reader, size)); err != nil {
+		b.Fatalf("Copy: %s", err)
+	}
+	_ = f.Close()
+	fi, _ := os.Stat(name)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("Listen: %s", err)
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(ioutil.Discard, c)
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatalf("Dial: %s", err)
+	}
+	defer func() { _ = c.Close() }()
+	tarW := NewTarWriter(c)
+	tarW.NoZeroCopy = noZeroCopy
+	entry := mkListEntry(os.TempDir(), fi)
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tarW.writeEntry(entry, tarW.openEntry(entry, 0), 0, -1); err != nil {
+			b.Fatalf("writeEntry: %s", err)
+		}
+	}
+}
+
+func BenchmarkWriteFileEntryZeroCopy(b *testing.B) {
+	benchmarkWriteFileEntry(b, false)
+}
+
+func BenchmarkWriteFileEntryBuffered(b *testing.B) {
+	benchmarkWriteFileEntry(b, true)
+}
+
+func TestFileReadModes(t *testing.T) {
+	f, err := ioutil.TempFile(os.TempDir(), "tarWriter.")
+	if err != nil {
+		t.Fatalf("TempFile: %s", err)
+	}
+	name := f.Name()
+	defer func() { _ = os.Remove(name) }()
+	if _, err := io.Copy(f, io.LimitReader(rand.Reader, 10+directIOAlign*3)); err != nil {
+		t.Fatalf("Copy: %s", err)
+	}
+	_ = f.Close()
+	fi, _ := os.Stat(name)
+	entry := mkListEntry(os.TempDir(), fi)
+	writeFile := func(tarW *TarWriter, buf *bytes.Buffer, skipbytes, maxbytes int64) []byte {
+		buf.Reset()
+		src := tarW.openEntry(entry, 0)
+		defer src.close()
+		if _, err := tarW.writeFileEntry(entry, src, skipbytes, maxbytes); err != nil {
+			t.Fatalf("writeFileEntry: %s", err)
+		}
+		return buf.Bytes()
+	}
+	buf := new(bytes.Buffer)
+	td := append([]byte{}, writeFile(NewTarWriter(buf), buf, 0, -1)...)
+	modes := []*TarWriter{
+		{w: buf, NoZeroCopy: true, BufferSize: 1000},
+		{w: buf, ReadAdvice: true},
+		{w: buf, NoZeroCopy: true, ReadAdvice: true},
+		{w: buf, ReadAdvice: true, BufferSize: 1000},
+		{w: buf, DirectIOSize: 1},
+		{w: buf, DirectIOSize: 1, BufferSize: directIOAlign},
+	}
+	for i, tarW := range modes {
+		for _, skipbytes := range []int64{0, 1, tarHeaderSize, tarHeaderSize + 1, tarHeaderSize + directIOAlign + 7} {
+			for _, maxbytes := range []int64{-1, 1, directIOAlign + 3} {
+				td2 := writeFile(tarW, buf, skipbytes, maxbytes)
+				if !bytes.Equal(maxBytes(td[skipbytes:], maxbytes), td2) {
+					t.Errorf("Not equal: mode %d, skip %d, max %d", i, skipbytes, maxbytes)
+				}
+			}
+		}
+	}
+}
+
+func TestHardlink(t *testing.T) {
+	tdirName, err := ioutil.TempDir(os.TempDir(), "tarhardlink.")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer func() { _ = os.RemoveAll(tdirName) }()
+	if err := ioutil.WriteFile(path.Join(tdirName, "a"), []byte("content"), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	for _, name := range []string{"b", "c"} {
+		if err := os.Link(path.Join(tdirName, "a"), path.Join(tdirName, name)); err != nil {
+			t.Skipf("Link: %s", err)
+		}
+	}
+	idx, err := ioutil.TempFile(os.TempDir(), "tarhardlink.idx.")
+	if err != nil {
+		t.Fatalf("TempFile: %s", err)
+	}
+	defer func() { _ = os.Remove(idx.Name()); _ = idx.Close() }()
+	if err := WriteIndex(tdirName, idx); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+	buf := new(bytes.Buffer)
+	ir, err := NewIndexReader(idx, buf, nil)
+	if err != nil {
+		t.Fatalf("NewIndexReader: %s", err)
+	}
+	if _, err := ir.SeekAndWrite("", 0, 0); err != nil {
+		t.Fatalf("SeekAndWrite: %s", err)
+	}
+	if int64(buf.Len()) != ir.Size() {
+		t.Errorf("Size %d != %d", buf.Len(), ir.Size())
+	}
+	tr := tar.NewReader(buf)
+	links := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next: %s", err)
+		}
+		switch path.Base(hdr.Name) {
+		case "a":
+			if hdr.Typeflag != tar.TypeReg || hdr.Size != 7 {
+				t.Errorf("File: %c %d", hdr.Typeflag, hdr.Size)
+			}
+		case "b", "c":
+			links++
+			if hdr.Typeflag != tar.TypeLink || path.Base(hdr.Linkname) != "a" || hdr.Size != 0 {
+				t.Errorf("Hardlink %s: %c %s %d", hdr.Name, hdr.Typeflag, hdr.Linkname, hdr.Size)
+			}
+		}
+	}
+	if links != 2 {
+		t.Errorf("Hardlinks: %d", links)
+	}
+}
+
+func TestXattrHeader(t *testing.T) {
+	tdirName, err := ioutil.TempDir(os.TempDir(), "tarxattr.")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer func() { _ = os.RemoveAll(tdirName) }()
+	name := path.Join(tdirName, "file")
+	if err := ioutil.WriteFile(name, []byte("content"), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	e := &ListEntry{
+		Name: name,
+		Type: EntryTypeFile,
+		Size: 7,
+		Meta: Metadata{MetaXattrPrefix + "user.test": "value", MetaXattrPrefix + "security.capability": "\x01\x00\x00\x02"},
+	}
+	buf := new(bytes.Buffer)
+	n, err := NewTarWriter(buf).WriteEntry(e, 0, -1)
+	if err != nil {
+		t.Fatalf("WriteEntry: %s", err)
+	}
+	if n != e.TarSize() || int64(buf.Len()) != n {
+		t.Errorf("Size %d, written %d, expected %d", buf.Len(), n, e.TarSize())
+	}
+	full := buf.Bytes()
+	for _, skip := range []int64{0, 1, tarBlockSize, tarBlockSize + 1, 2 * tarBlockSize, 3*tarBlockSize + 5} {
+		for _, max := range []int64{-1, 1, tarBlockSize, 3 * tarBlockSize} {
+			part := new(bytes.Buffer)
+			if _, err := NewTarWriter(part).WriteEntry(e, skip, max); err != nil {
+				t.Fatalf("WriteEntry %d %d: %s", skip, max, err)
+			}
+			if expect := maxBytes(full[skip:], max); !bytes.Equal(part.Bytes(), expect) {
+				t.Errorf("Skip %d, max %d: %d bytes, expected %d", skip, max, part.Len(), len(expect))
+			}
+		}
+	}
+	hdr, err := tar.NewReader(bytes.NewReader(append(full, make([]byte, tarFooterSize)...))).Next()
+	if err != nil {
+		t.Fatalf("Next: %s", err)
+	}
+	if hdr.Name != name || hdr.Size != 7 {
+		t.Errorf("Header: %s %d", hdr.Name, hdr.Size)
+	}
+	if hdr.PAXRecords["SCHILY.xattr.user.test"] != "value" || hdr.PAXRecords["SCHILY.xattr.security.capability"] != "\x01\x00\x00\x02" {
+		t.Errorf("PAX records: %v", hdr.PAXRecords)
+	}
+}
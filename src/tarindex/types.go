@@ -1,6 +1,9 @@
 package tarindex
 
-import "archive/tar"
+import (
+	"archive/tar"
+	"fmt"
+)
 
 const (
 	tarHeaderFormat = tar.FormatUSTAR
@@ -29,17 +32,44 @@ var zeroBlock block
 type EntryType byte
 
 const (
-	EntryTypeHeader    EntryType = 0xff
-	EntryTypeDirectory EntryType = 0x01
-	EntryTypeFile      EntryType = 0x02
-	EntryTypeLink      EntryType = 0x03
+	EntryTypeHeader      EntryType = 0xff
+	EntryTypeHeaderMeta  EntryType = 0xfd // Metadata of the index. Follows the header.
+	EntryTypeMeta        EntryType = 0xfe // Metadata of the next entry.
+	EntryTypeDirectory   EntryType = 0x01
+	EntryTypeFile        EntryType = 0x02
+	EntryTypeLink        EntryType = 0x03
+	EntryTypeHardlink    EntryType = 0x04 // Hard link to an earlier entry, named by MetaLinkPath.
+	EntryTypeFifo        EntryType = 0x05
+	EntryTypeCharDevice  EntryType = 0x06
+	EntryTypeBlockDevice EntryType = 0x07
 )
 
+var entryTypeNames = map[EntryType]string{
+	EntryTypeHeader:      "header",
+	EntryTypeHeaderMeta:  "headermeta",
+	EntryTypeMeta:        "meta",
+	EntryTypeDirectory:   "dir",
+	EntryTypeFile:        "file",
+	EntryTypeLink:        "symlink",
+	EntryTypeHardlink:    "hardlink",
+	EntryTypeFifo:        "fifo",
+	EntryTypeCharDevice:  "chardev",
+	EntryTypeBlockDevice: "blockdev",
+}
+
+func (t EntryType) String() string {
+	if name, ok := entryTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(0x%02x)", byte(t))
+}
+
 // ListEntry describes an entry in a list of tar file entries.
 type ListEntry struct {
 	Size      int64     // Size of the entry.
 	Name      string    // Path of filesystem object.
-	Type      EntryType // Directory, link, or regular file.
+	Type      EntryType // Directory, link, hard link, regular file, FIFO or device.
 	FirstByte int64     // First byte occupied in the tar file. Only populated when reading.
 	LastByte  int64     // Last byte occupied in the tar file. Only populated when reading.
+	Meta      Metadata  // Optional metadata of the entry.
 }
@@ -9,6 +9,48 @@ func isRegular(fi os.FileInfo) bool {
 	return mode & ^os.ModeType == mode
 }
 
+func isFifo(fi os.FileInfo) bool {
+	return fi.Mode()&os.ModeNamedPipe != 0
+}
+
+func isCharDevice(fi os.FileInfo) bool {
+	mode := fi.Mode()
+	return mode&os.ModeDevice != 0 && mode&os.ModeCharDevice != 0
+}
+
+func isBlockDevice(fi os.FileInfo) bool {
+	mode := fi.Mode()
+	return mode&os.ModeDevice != 0 && mode&os.ModeCharDevice == 0
+}
+
+// specialType returns the entry type of FIFOs and devices, or false for other files.
+func specialType(fi os.FileInfo) (EntryType, bool) {
+	switch {
+	case isFifo(fi):
+		return EntryTypeFifo, true
+	case isCharDevice(fi):
+		return EntryTypeCharDevice, true
+	case isBlockDevice(fi):
+		return EntryTypeBlockDevice, true
+	default:
+		return 0, false
+	}
+}
+
+// fileEntryType returns the entry type of a filesystem object, and false if it cannot be indexed.
+func fileEntryType(fi os.FileInfo) (EntryType, bool) {
+	switch {
+	case fi.IsDir():
+		return EntryTypeDirectory, true
+	case isLink(fi):
+		return EntryTypeLink, true
+	case isRegular(fi):
+		return EntryTypeFile, true
+	default:
+		return specialType(fi)
+	}
+}
+
 func isLink(fi os.FileInfo) bool {
 	mode := fi.Mode()
 	return mode&os.ModeSymlink != 0
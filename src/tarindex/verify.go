@@ -0,0 +1,100 @@
+package tarindex
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// VerifyIndex checks the entries of the index in r against the filesystem: Existence, type, size and, if recorded
+// in the index, modification time. Digests are compared if digest is true and they are recorded in the index.
+// report is called for every entry that does not match, with an error that wraps ErrIndexFSMismatch, or with the
+// error that prevented checking it. VerifyIndex returns the number of reported entries. For an index of a tar file
+// (WriteTarIndex) only size and modification time of the tar file are checked, it is reported as the only entry.
+func VerifyIndex(r io.Reader, digest bool, report func(e *ListEntry, err error)) (int, error) {
+	s, meta, err := scanIndex(r)
+	if err != nil {
+		return 0, err
+	}
+	var mismatches int
+	check := func(e *ListEntry, err error) {
+		if err != nil {
+			mismatches++
+			if report != nil {
+				report(e, err)
+			}
+		}
+	}
+	if name := meta[MetaTarFile]; name != "" {
+		check(&ListEntry{Name: name, Type: EntryTypeFile}, verifyTarFile(name, meta))
+		return mismatches, nil
+	}
+	for {
+		e, err := s.next()
+		if err == io.EOF {
+			return mismatches, nil
+		} else if err != nil {
+			return mismatches, err
+		}
+		check(e, verifyEntry(e, digest))
+	}
+}
+
+// verifyTarFile checks the tar file name against the size and modification time recorded in the index metadata meta.
+func verifyTarFile(name string, meta Metadata) error {
+	fi, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return mismatch("missing")
+	} else if err != nil {
+		return err
+	}
+	if size, err := strconv.ParseInt(meta[MetaTarSize], 10, 64); err == nil && fi.Size() != size {
+		return mismatch("size %d, indexed %d", fi.Size(), size)
+	}
+	if mtime, err := strconv.ParseInt(meta[MetaTarMTime], 10, 64); err == nil && fi.ModTime().Unix() != mtime {
+		return mismatch("modification time %d, indexed %d", fi.ModTime().Unix(), mtime)
+	}
+	return nil
+}
+
+func mismatch(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrIndexFSMismatch, fmt.Sprintf(format, args...))
+}
+
+// verifyEntry checks e, as read from an index, against the filesystem.
+func verifyEntry(e *ListEntry, digest bool) error {
+	fi, err := os.Lstat(e.Name)
+	if os.IsNotExist(err) {
+		return mismatch("missing")
+	} else if err != nil {
+		return err
+	}
+	expected := e.Type
+	if expected == EntryTypeHardlink {
+		expected = EntryTypeFile
+	}
+	if entryType, ok := fileEntryType(fi); !ok || entryType != expected {
+		return mismatch("type %s", fi.Mode().Type())
+	}
+	if e.Type != EntryTypeFile {
+		return nil
+	}
+	if size, changed := indexedSize(e, fi.Size()); changed {
+		return mismatch("size %d, indexed %d", fi.Size(), size)
+	}
+	if _, mtime, ok := e.Meta.stat(); ok && fi.ModTime().Unix() != mtime {
+		return mismatch("modification time %d, indexed %d", fi.ModTime().Unix(), mtime)
+	}
+	if indexed := e.Meta[MetaDigest]; digest && indexed != "" {
+		d, err := fileDigest(e.Name)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(d, indexed) {
+			return mismatch("digest %s, indexed %s", d, indexed)
+		}
+	}
+	return nil
+}
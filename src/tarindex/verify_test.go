@@ -0,0 +1,83 @@
+package tarindex
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestVerifyIndex(t *testing.T) {
+	tdirName := writeTestTree(t)
+	defer func() { _ = os.RemoveAll(tdirName) }()
+	for _, options := range [][]Option{nil, {OptStat}, {OptDigest}} {
+		idx := new(bytes.Buffer)
+		if err := WriteIndex(tdirName, idx, options...); err != nil {
+			t.Fatalf("WriteIndex: %s", err)
+		}
+		verify := func() map[string]error {
+			reported := make(map[string]error)
+			n, err := VerifyIndex(bytes.NewReader(idx.Bytes()), true, func(e *ListEntry, err error) {
+				reported[path.Base(e.Name)] = err
+			})
+			if err != nil {
+				t.Fatalf("VerifyIndex: %s", err)
+			}
+			if n != len(reported) {
+				t.Errorf("Mismatches %d, reported %d", n, len(reported))
+			}
+			return reported
+		}
+		if reported := verify(); len(reported) != 0 {
+			t.Errorf("Options %v: Unexpected mismatches %v", options, reported)
+		}
+	}
+
+	idx := new(bytes.Buffer)
+	if err := WriteIndex(tdirName, idx, OptDigest); err != nil {
+		t.Fatalf("WriteIndex: %s", err)
+	}
+	if err := os.Remove(path.Join(tdirName, "dir0", "file5")); err != nil {
+		t.Fatalf("Remove: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(tdirName, "dir1", "file6"), []byte("changed"), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	name := path.Join(tdirName, "dir2", "file7")
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if err := os.Chtimes(name, fi.ModTime(), fi.ModTime().Add(-time.Hour)); err != nil {
+		t.Fatalf("Chtimes: %s", err)
+	}
+	name = path.Join(tdirName, "dir3", "file8")
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+	content[0]++
+	if err := ioutil.WriteFile(name, content, 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	if err := os.Chtimes(name, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatalf("Chtimes: %s", err)
+	}
+	reported := make(map[string]error)
+	if _, err := VerifyIndex(bytes.NewReader(idx.Bytes()), true, func(e *ListEntry, err error) {
+		reported[path.Base(e.Name)] = err
+	}); err != nil {
+		t.Fatalf("VerifyIndex: %s", err)
+	}
+	for _, name := range []string{"file5", "file6", "file7", "file8"} {
+		if !errors.Is(reported[name], ErrIndexFSMismatch) {
+			t.Errorf("%s: %v", name, reported[name])
+		}
+	}
+	if len(reported) != 4 {
+		t.Errorf("Mismatches: %v", reported)
+	}
+}
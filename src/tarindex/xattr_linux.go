@@ -0,0 +1,51 @@
+//go:build linux
+// +build linux
+
+package tarindex
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+)
+
+// readXattrs returns the extended attributes of name as metadata. Attributes removed while reading are left out.
+// It returns an error if the attributes cannot be read, but not if the filesystem does not support them.
+func readXattrs(name string) (Metadata, error) {
+	size, err := syscall.Listxattr(name, nil)
+	if errors.Is(err, syscall.ENOTSUP) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(name, buf); err != nil {
+		return nil, err
+	}
+	var m Metadata
+	for _, attr := range strings.Split(string(buf[:size]), "\x00") {
+		if attr == "" {
+			continue
+		}
+		n, err := syscall.Getxattr(name, attr, nil)
+		if errors.Is(err, syscall.ENODATA) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		value := make([]byte, n)
+		if n, err = syscall.Getxattr(name, attr, value); errors.Is(err, syscall.ENODATA) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			m = make(Metadata)
+		}
+		m[MetaXattrPrefix+attr] = string(value[:n])
+	}
+	return m, nil
+}
@@ -0,0 +1,46 @@
+package tarindex
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+)
+
+func TestReadXattrs(t *testing.T) {
+	tdirName, err := ioutil.TempDir(os.TempDir(), "tarxattr.")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer func() { _ = os.RemoveAll(tdirName) }()
+	name := path.Join(tdirName, "file")
+	if err := ioutil.WriteFile(name, []byte("content"), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	if _, err := readXattrs(path.Join(tdirName, "missing")); !os.IsNotExist(err) {
+		t.Errorf("Missing file: %v", err)
+	}
+	if err := syscall.Setxattr(name, "user.test", []byte("value"), 0); err != nil {
+		t.Skipf("Setxattr: %s", err)
+	}
+	var meta Metadata
+	entryFunc := func(e *ListEntry) error {
+		if e.Name == name {
+			meta = e.Meta
+		}
+		return nil
+	}
+	if err := ListToFunc(tdirName, entryFunc); err != nil {
+		t.Fatalf("ListToFunc: %s", err)
+	}
+	if meta != nil {
+		t.Errorf("Captured without OptXattrs: %v", meta)
+	}
+	if err := ListToFunc(tdirName, entryFunc, OptXattrs); err != nil {
+		t.Fatalf("ListToFunc: %s", err)
+	}
+	if meta[MetaXattrPrefix+"user.test"] != "value" {
+		t.Errorf("Metadata: %v", meta)
+	}
+}
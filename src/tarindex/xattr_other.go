@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package tarindex
+
+// readXattrs is not supported, extended attributes are not captured.
+func readXattrs(name string) (Metadata, error) {
+	return nil, nil
+}
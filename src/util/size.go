@@ -0,0 +1,38 @@
+package util
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrSize is returned for sizes that cannot be parsed.
+var ErrSize = errors.New("invalid size")
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"TB", 1000 * 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// ParseSize parses a positive number of bytes with an optional unit: B, KB, MB, GB, TB (powers of 1000), or KiB, MiB,
+// GiB, TiB and K, M, G, T (powers of 1024).
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, factor = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 || n > (1<<63-1)/factor {
+		return 0, ErrSize
+	}
+	return n * factor, nil
+}